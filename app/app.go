@@ -17,28 +17,49 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/asokolov365/YamlPartitioner/lib/balance"
 	"github.com/asokolov365/YamlPartitioner/lib/filesutil"
+	"github.com/asokolov365/YamlPartitioner/lib/generations"
 	"github.com/asokolov365/YamlPartitioner/lib/partitioner"
+	"github.com/asokolov365/YamlPartitioner/lib/state"
 )
 
 var mainJob *job
 
 // Init initializes the partitioning job.
 func Init() error {
-	// inputFiles, err := filesreader.ListFiles(*MainConfig.SrcFilePath)
-	inputFiles, err := filesutil.List(*MainConfig.SrcFilePath)
+	inputFiles, companionFiles, err := listInputFiles()
 	if err != nil {
-		return fmt.Errorf("failed to list files: %w", err)
+		return err
 	}
 
-	if len(inputFiles) < 1 {
-		return fmt.Errorf("no file(s) found for pattern %q", *MainConfig.SrcFilePath)
+	if *MainConfig.FailFast && *MainConfig.KeepGoing {
+		return fmt.Errorf("fail-fast and keep-going are mutually exclusive")
+	}
+
+	if *MainConfig.SkewBy != skewByItems && *MainConfig.SkewBy != skewByBytes {
+		return fmt.Errorf("invalid skew load measure: %q", *MainConfig.SkewBy)
+	}
+
+	fileTimeout, err := MainConfig.fileTimeout()
+	if err != nil {
+		return err
+	}
+
+	totalTimeout, err := MainConfig.totalTimeout()
+	if err != nil {
+		return err
 	}
 
 	tmpDir, err := os.MkdirTemp(os.TempDir(), "yp.")
@@ -46,23 +67,33 @@ func Init() error {
 		return fmt.Errorf("failed to create temp dir: %w", err)
 	}
 
-	cfg, err := partitioner.NewConfig(
-		partitioner.WithConsistentHashing(MainConfig.ConsistentHashing()),
-		partitioner.WithReplicasCount(*MainConfig.ReplicationFactor),
-		partitioner.WithSplitPoint(*MainConfig.SplitPointPath),
-		partitioner.WithThisShardID(*MainConfig.ShardID),
+	opts, err := MainConfig.partitionerOptions()
+	if err != nil {
+		return fmt.Errorf("failed to init partitioner config: %w", err)
+	}
+
+	opts = append(opts,
 		partitioner.WithWorkingDirectory(tmpDir),
+		partitioner.WithTimeout(fileTimeout),
 	)
+
+	cfg, err := partitioner.NewConfig(opts...)
 	if err != nil {
 		return fmt.Errorf("failed to init partitioner config: %w", err)
 	}
 
 	mainJob = &job{
 		cfg:          cfg,
-		partitioners: make(map[string]*partitioner.Partitioner, len(inputFiles)),
+		partitioners: make([]*partitioner.Partitioner, 0, len(inputFiles)),
+		parallelism:  MainConfig.parallelism(),
+		totalTimeout: totalTimeout,
+		failFast:     *MainConfig.FailFast,
+		companions:   make([]output, 0, len(companionFiles)),
 	}
 
-	commonPath := filesutil.LongestCommonPath(inputFiles)
+	// The companion files keep the same relative layout as the input files.
+	commonPath := commonPath(inputFiles, companionFiles)
+	outputs := make([]output, 0, len(inputFiles)+len(companionFiles))
 
 	for _, file := range inputFiles {
 		p, err := partitioner.WithConfig(cfg, file, commonPath)
@@ -70,20 +101,42 @@ func Init() error {
 			return fmt.Errorf("failed to init partitioner instance: %w", err)
 		}
 
-		mainJob.partitioners[file] = p
+		mainJob.partitioners = append(mainJob.partitioners, p)
+		outputs = append(outputs, output{inputFile: p.InputFile(), outputFile: p.OutputFile()})
+	}
+
+	for _, file := range companionFiles {
+		c := output{inputFile: file, outputFile: strings.TrimPrefix(file, commonPath)}
+
+		mainJob.companions = append(mainJob.companions, c)
+		outputs = append(outputs, c)
+	}
+
+	if err := checkCollisions(outputs); err != nil {
+		return err
 	}
 
 	return nil
 }
 
 type job struct {
-	cfg          *partitioner.Config
-	partitioners map[string]*partitioner.Partitioner
-	mu           sync.Mutex
+	cfg *partitioner.Config
+	// partitioners are sorted by the input file.
+	partitioners []*partitioner.Partitioner
+	// parallelism is how many files are partitioned concurrently.
+	parallelism int
+	// totalTimeout is the max duration of the job, 0 means no deadline.
+	totalTimeout time.Duration
+	// failFast stops the job on the first failure, see partitioner.RunAll.
+	failFast bool
+	// companions are the files copied to all shards as is, see --copy-to-all.
+	companions []output
+	mu         sync.Mutex
 }
 
-// Run starts the partitioning.
-func Run(ctx context.Context, verbose bool) error {
+// Run starts the partitioning and reports
+// whether any output file is added, changed or removed.
+func Run(ctx context.Context, verbose bool) (bool, error) {
 	if mainJob == nil {
 		panic("BUG: mainJob is not initialized")
 	}
@@ -91,51 +144,74 @@ func Run(ctx context.Context, verbose bool) error {
 	return mainJob.run(ctx, verbose)
 }
 
-func (job *job) run(ctx context.Context, verbose bool) error {
+func (job *job) run(ctx context.Context, verbose bool) (bool, error) {
 	job.mu.Lock()
 	defer job.mu.Unlock()
 
 	if err := os.MkdirAll(*MainConfig.DstDirPath, 0o755); err != nil {
-		return fmt.Errorf("failed to make directory %q: %w", *MainConfig.DstDirPath, err)
+		return false, fmt.Errorf("failed to make directory %q: %w", *MainConfig.DstDirPath, err)
 	}
 
 	var (
 		reports    = make([]string, 0, len(job.partitioners))
-		errs       = make([]string, 0, len(job.partitioners))
 		itemsCount = make(map[string]int, job.cfg.NodesCount())
-		wg         sync.WaitGroup
+		bytesCount = make(map[string]int, job.cfg.NodesCount())
+		broadcast  = make(map[string]int, job.cfg.NodesCount())
+		replicas   = make(map[int]int)
+		dropped    int
+		routed     = make([]int, len(job.cfg.Routes()))
+		directed   = make(map[string]int)
 	)
 
-	startTime := time.Now()
-
-	for _, prt := range job.partitioners {
-		wg.Add(1)
+	if job.totalTimeout > 0 {
+		var cancel context.CancelFunc
 
-		go func(p *partitioner.Partitioner) {
-			if err := p.Run(ctx); err != nil {
-				errs = append(errs, fmt.Sprintf("[!] %s", err.Error()))
-			}
-
-			wg.Done()
-		}(prt)
+		ctx, cancel = context.WithTimeout(ctx, job.totalTimeout)
+		defer cancel()
 	}
 
-	wg.Wait()
+	startTime := time.Now()
+
+	errs := partitioner.RunAll(ctx, job.partitioners, job.parallelism, job.failFast)
 
 	finishTime := time.Since(startTime)
 
 	fmt.Fprintf(os.Stderr, "Partitioning of %d yaml files finished in %d ms\n",
 		len(job.partitioners), finishTime.Milliseconds())
 
+	if len(*MainConfig.HashSeed) > 0 {
+		fmt.Fprintf(os.Stderr, "Consistent hashing %q with hash seed %q\n", *MainConfig.Algorithm, *MainConfig.HashSeed)
+	}
+
+	if timedOut := timedOut(errs); len(timedOut) > 0 {
+		fmt.Fprintf(os.Stderr, "%d file(s) hit the deadline:\n%s\n",
+			len(timedOut), strings.Join(timedOut, "\n"))
+	}
+
 	select {
 	case <-ctx.Done():
 		os.RemoveAll(job.cfg.WorkDir())
 
-		return fmt.Errorf("context canceled: %w", ctx.Err())
+		return false, fmt.Errorf("context canceled: %w", ctx.Err())
 
 	default:
-		if err := filesutil.MoveDirAll(job.cfg.WorkDir(), *MainConfig.DstDirPath); err != nil {
-			errs = append(errs, fmt.Sprintf("[!] %s", err.Error()))
+	}
+
+	// Nothing is published on the first failure in the fail fast mode.
+	if job.failFast && errs.Len() > 0 {
+		os.RemoveAll(job.cfg.WorkDir())
+
+		return false, errs
+	}
+
+	copyErr := job.copyToAll()
+	if copyErr != nil {
+		errs.Add(copyErr)
+
+		if job.failFast {
+			os.RemoveAll(job.cfg.WorkDir())
+
+			return false, errs
 		}
 	}
 
@@ -145,6 +221,28 @@ func (job *job) run(ctx context.Context, verbose bool) error {
 		for shardName, count := range p.ShardItemsCount() {
 			itemsCount[shardName] += count
 		}
+
+		for shardName, count := range p.ShardBytesCount() {
+			bytesCount[shardName] += count
+		}
+
+		for shardName, count := range p.ShardBroadcastCount() {
+			broadcast[shardName] += count
+		}
+
+		dropped += p.DroppedItemsCount()
+
+		for rf, count := range p.ReplicasItemsCount() {
+			replicas[rf] += count
+		}
+
+		for i, count := range p.RouteItemsCount() {
+			routed[i] += count
+		}
+
+		for name, count := range p.DirectiveItemsCount() {
+			directed[name] += count
+		}
 	}
 
 	// for keeping sorted order of shards iterating over job.cfg.NodeNames()
@@ -155,19 +253,320 @@ func (job *job) run(ctx context.Context, verbose bool) error {
 			continue
 		}
 
-		fmt.Fprintf(os.Stderr, "Shard %q got %d items in total\n", name, itemsCount[name])
+		if broadcast[name] > 0 {
+			fmt.Fprintf(os.Stderr, "Shard %q got %d items in total, %d of them broadcast\n",
+				name, itemsCount[name], broadcast[name])
+		} else {
+			fmt.Fprintf(os.Stderr, "Shard %q got %d items in total\n", name, itemsCount[name])
+		}
+	}
+
+	for i, r := range job.cfg.Routes() {
+		fmt.Fprintf(os.Stderr, "Route %s captured %d items in total\n", r, routed[i])
+	}
+
+	if len(job.cfg.Drop()) > 0 {
+		fmt.Fprintf(os.Stderr, "Dropped %d items in total\n", dropped)
+	}
+
+	if len(job.cfg.ReplicationOverrides()) > 0 {
+		rfs := make([]int, 0, len(replicas))
+		for rf := range replicas {
+			rfs = append(rfs, rf)
+		}
+
+		sort.Ints(rfs)
+
+		for _, rf := range rfs {
+			fmt.Fprintf(os.Stderr, "Items with RF=%d: %d in total\n", rf, replicas[rf])
+		}
+	}
+
+	for _, name := range []string{partitioner.DirectiveAll, partitioner.DirectivePin, partitioner.DirectiveSkip} {
+		if count, ok := directed[name]; ok {
+			fmt.Fprintf(os.Stderr, "Directive %q placed %d items in total\n", name, count)
+		}
+	}
+
+	if len(job.companions) > 0 && copyErr == nil {
+		fmt.Fprintf(os.Stderr, "Copied %d companion file(s) to every shard\n", len(job.companions))
+	}
+
+	for _, c := range job.companions {
+		reports = append(reports, fmt.Sprintf("===> Copying %q to every shard as is", c.outputFile))
 	}
 
 	if verbose && len(reports) > 0 {
 		fmt.Fprintln(os.Stderr, strings.Join(reports, "\n"))
 	}
 
-	if len(errs) > 0 {
-		return fmt.Errorf(
-			"partitioning finished with %d error(s):\n%s",
-			len(errs),
-			strings.Join(errs, "\n"),
-		)
+	// The skew is checked before moving the result to the destination
+	// to not publish unbalanced shards.
+	if errs.Len() == 0 {
+		if err := job.checkSkew(itemsCount, broadcast, bytesCount); err != nil {
+			os.RemoveAll(job.cfg.WorkDir())
+
+			return false, err
+		}
+	}
+
+	changes, err := job.publish(errs)
+	if err != nil {
+		errs.Add(err)
+	}
+
+	if changes != nil {
+		printChanges(changes, verbose)
+	}
+
+	if errs.Len() > 0 {
+		return false, errs
+	}
+
+	return changes != nil && changes.Any(), nil
+}
+
+// copyToAll copies the companion files into the working directory
+// of every shard under the same relative path as the input files.
+func (job *job) copyToAll() error {
+	for i, name := range job.cfg.NodeNames() {
+		// Skipping partitioning if ShardID has set
+		if *MainConfig.ShardID >= 0 && *MainConfig.ShardID != i {
+			continue
+		}
+
+		for _, c := range job.companions {
+			dstFile := filepath.Join(job.cfg.WorkDir(), name, c.outputFile)
+
+			if err := os.MkdirAll(filepath.Dir(dstFile), 0o755); err != nil {
+				return fmt.Errorf("failed to make directory %q: %w", filepath.Dir(dstFile), err)
+			}
+
+			if err := filesutil.CopyContent(c.inputFile, dstFile); err != nil {
+				return fmt.Errorf("failed to copy companion file to shard %q: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// publish moves the result from the working directory to the destination
+// and returns the changes of the output files.
+// If generations are enabled, the result is published as a new generation
+// only if there are no errors and anything changed, otherwise the files
+// partitioned successfully are copied into the destination directly.
+func (job *job) publish(errs *partitioner.Errors) (*filesutil.Changes, error) {
+	if *MainConfig.Generations < 1 {
+		return job.publishInPlace(errs)
+	}
+
+	defer os.RemoveAll(job.cfg.WorkDir())
+
+	if errs.Len() > 0 {
+		fmt.Fprintln(os.Stderr, "Nothing is published due to errors")
+		return nil, nil
+	}
+
+	store, err := generations.New(*MainConfig.DstDirPath, *MainConfig.Generations)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := store.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes *filesutil.Changes
+
+	if len(current) > 0 {
+		changes, err = filesutil.CompareDirAll(job.cfg.WorkDir(), store.Path(current))
+		if err != nil {
+			return nil, err
+		}
+
+		// A new generation would make the readers reload the same content.
+		if !changes.Any() {
+			fmt.Fprintf(os.Stderr, "Nothing changed, generation %q is kept current\n", current)
+			return changes, nil
+		}
+	} else {
+		produced, err := filesutil.ListTree(job.cfg.WorkDir())
+		if err != nil {
+			return nil, err
+		}
+
+		changes = &filesutil.Changes{Added: produced}
+	}
+
+	id, err := store.Publish(job.cfg.WorkDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish generation: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Published generation %q\n", id)
+
+	return changes, nil
+}
+
+// publishInPlace copies the changed files into the destination directory
+// and records the produced files in the state file. If --prune is set
+// and there are no errors, the files produced by the previous runs
+// but not by this one are removed.
+func (job *job) publishInPlace(errs *partitioner.Errors) (*filesutil.Changes, error) {
+	dstDir := *MainConfig.DstDirPath
+
+	st, err := state.Load(dstDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The files with the same content are not rewritten
+	// to not make the readers reload them.
+	changes, err := filesutil.UpdateDirAll(job.cfg.WorkDir(), dstDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.RemoveAll(job.cfg.WorkDir()); err != nil {
+		return nil, fmt.Errorf("failed to remove %q: %w", job.cfg.WorkDir(), err)
+	}
+
+	produced := make([]string, 0, len(changes.Added)+len(changes.Changed)+len(changes.Unchanged))
+	produced = append(produced, changes.Added...)
+	produced = append(produced, changes.Changed...)
+	produced = append(produced, changes.Unchanged...)
+
+	st.Add(produced...)
+
+	// The outputs of the failed files are not produced,
+	// so they must not be pruned.
+	if *MainConfig.Prune && errs.Len() == 0 {
+		var scope []string
+		if *MainConfig.ShardID >= 0 {
+			// The other shards may be written by other instances.
+			scope = []string{job.cfg.NodeNames()[*MainConfig.ShardID]}
+		}
+
+		stale := st.Stale(produced, scope...)
+
+		pruneErr := st.Prune(dstDir, stale)
+		if pruneErr == nil {
+			changes.Removed = stale
+		}
+
+		if err := st.Save(dstDir); err != nil {
+			return nil, err
+		}
+
+		return changes, pruneErr
+	}
+
+	return changes, st.Save(dstDir)
+}
+
+// printChanges prints the summary of the changes of the output files,
+// and the changed files themselves if verbose is true.
+func printChanges(changes *filesutil.Changes, verbose bool) {
+	fmt.Fprintf(os.Stderr, "Output files: %s\n", changes)
+
+	if !verbose {
+		return
+	}
+
+	for _, list := range []struct {
+		mark  string
+		files []string
+	}{
+		{"[+]", changes.Added},
+		{"[~]", changes.Changed},
+		{"[-]", changes.Removed},
+	} {
+		for _, file := range list.files {
+			fmt.Fprintf(os.Stderr, "%s %s\n", list.mark, file)
+		}
+	}
+}
+
+// Rollback switches the current generation in the destination directory
+// to the one with the given id, or to the previous one if id is empty.
+func Rollback(w io.Writer, id string) error {
+	if *MainConfig.Generations < 1 {
+		return fmt.Errorf("generations are not enabled, see --generations")
+	}
+
+	store, err := generations.New(*MainConfig.DstDirPath, *MainConfig.Generations)
+	if err != nil {
+		return err
+	}
+
+	id, err = store.Rollback(id)
+	if err != nil {
+		return fmt.Errorf("failed to roll back: %w", err)
+	}
+
+	fmt.Fprintf(w, "Current generation is %q\n", id)
+
+	return nil
+}
+
+// timedOut returns the input files that hit either
+// the file deadline or the total deadline.
+func timedOut(errs *partitioner.Errors) []string {
+	var files []string
+
+	for _, err := range errs.List() {
+		if errors.Is(err, context.DeadlineExceeded) {
+			files = append(files, fmt.Sprintf("[!] %s", err.File))
+		}
+	}
+
+	return files
+}
+
+// checkSkew compares the load of each shard with the mean load
+// and returns SkewError if any shard deviates more than allowed.
+// The broadcast items are the same for all shards, so they are
+// not counted as the load, see --broadcast.
+func (job *job) checkSkew(itemsCount, broadcast, bytesCount map[string]int) error {
+	if *MainConfig.MaxSkew <= 0 {
+		return nil
+	}
+
+	// The skew makes sense only if all shards are partitioned.
+	if *MainConfig.ShardID >= 0 {
+		return nil
+	}
+
+	counts := itemsCount
+	if *MainConfig.SkewBy == skewByBytes {
+		counts = bytesCount
+	}
+
+	loads := make(map[string]float64, job.cfg.NodesCount())
+	for _, name := range job.cfg.NodeNames() {
+		loads[name] = float64(counts[name])
+
+		if *MainConfig.SkewBy == skewByItems {
+			loads[name] -= float64(broadcast[name])
+		}
+	}
+
+	offending := make(map[string]float64)
+
+	for name, skew := range balance.Skew(loads) {
+		if math.Abs(skew) > *MainConfig.MaxSkew {
+			offending[name] = skew
+		}
+	}
+
+	if len(offending) > 0 {
+		return &SkewError{
+			Shards:  offending,
+			By:      *MainConfig.SkewBy,
+			MaxSkew: *MainConfig.MaxSkew,
+		}
 	}
 
 	return nil
@@ -16,8 +16,12 @@ package app
 
 import (
 	"fmt"
+	"os"
+	"runtime"
+	"time"
 
 	"github.com/asokolov365/YamlPartitioner/lib/hrw"
+	"github.com/asokolov365/YamlPartitioner/lib/jump"
 	"github.com/asokolov365/YamlPartitioner/lib/partitioner"
 	"github.com/cespare/xxhash/v2"
 )
@@ -35,29 +39,87 @@ var (
 // from the config file, ENV vars, or flags.
 func InitConfig() {
 	splitPointPath := "*"
-	srcFilePath := "./**/*.{yml,yaml}"
+	format := "auto"
+	mergeKeys := partitioner.MergeKeysKeep
+	pruneEmpty := partitioner.PruneEmptyNone
 	dstDirPath := "/tmp"
 	shardBaseName := "instance"
 	shardsNumber := 0
 	shardID := -1
 	replicationFactor := 1
+	algorithm := algorithmHRW
+	hashSeed := ""
+	maxSkew := 0.0
+	skewBy := skewByItems
+	parallelism := 0
+	fileTimeout := "10s"
+	totalTimeout := "0s"
+	failFast := false
+	keepGoing := false
+	generations := 0
+	prune := false
+	exitCodeOnChange := false
+	filesFrom := ""
+	routesFile := ""
+	stripDirectives := false
 	MainConfig = &Config{
 		SplitPointPath:    &splitPointPath,
-		SrcFilePath:       &srcFilePath,
+		Format:            &format,
+		MergeKeys:         &mergeKeys,
+		PruneEmpty:        &pruneEmpty,
 		DstDirPath:        &dstDirPath,
 		ShardBaseName:     &shardBaseName,
 		ShardsNumber:      &shardsNumber,
 		ShardID:           &shardID,
 		ReplicationFactor: &replicationFactor,
+		Algorithm:         &algorithm,
+		HashSeed:          &hashSeed,
+		MaxSkew:           &maxSkew,
+		SkewBy:            &skewBy,
+		Parallelism:       &parallelism,
+		FileTimeout:       &fileTimeout,
+		TotalTimeout:      &totalTimeout,
+		FailFast:          &failFast,
+		KeepGoing:         &keepGoing,
+		Generations:       &generations,
+		Prune:             &prune,
+		ExitCodeOnChange:  &exitCodeOnChange,
+		FilesFrom:         &filesFrom,
+		RoutesFile:        &routesFile,
+		StripDirectives:   &stripDirectives,
 	}
 }
 
 // Config represents the *yp* configuration.
 type Config struct {
 	// Split point path in YAML, e.g. 'groups.*.rules'. This must be a SequenceNode or MappingNode."
-	SplitPointPath *string `mapstructure:"split-at,omitempty" usage:"REQUIRED. Split point path in YAML, e.g. 'groups.*.rules'. This must be a YAML SequenceNode or MappingNode." env:"YP_SPLIT_POINT"`
-	// Path to input YAML file or directory that needs to be partitioned.
-	SrcFilePath *string `mapstructure:"src,omitempty" usage:"REQUIRED. Path to input YAML file or directory that needs to be partitioned." env:"YP_SRC_PATH"`
+	SplitPointPath *string `mapstructure:"split-at,omitempty" usage:"REQUIRED. Split point path in YAML, e.g. 'groups.*.rules'. This must be a YAML SequenceNode or MappingNode. '@documents' makes each document of a multi-document YAML an item, '@file' makes each input file an item copied to its shards as is. '@lines' makes each line of a plain text file an item, '@csv:<column>' makes each record of a CSV file an item hashed by the column. '.' makes each element of the root list or map an item." env:"YP_SPLIT_POINT"`
+	// Format of the input and output files, either "auto", "yaml", "json" or "toml".
+	Format *string `mapstructure:"format,omitempty" usage:"Format of the input and output files: 'yaml', 'json' or 'toml'. If 'auto', the format of each file is detected by its extension: '.json', '.toml', otherwise YAML." env:"YP_FORMAT"`
+	// Policy for the YAML merge keys of a map split point, either "keep" or "expand".
+	MergeKeys *string `mapstructure:"merge-keys,omitempty" usage:"Policy for the YAML merge keys ('<<') of a map split point: 'keep' keeps them in every shard, 'expand' replaces them with the keys they merge, so each merged key is partitioned as an item." env:"YP_MERGE_KEYS"`
+	// Policy for the containers of the split point, which got no items in a shard.
+	PruneEmpty *string `mapstructure:"prune-empty,omitempty" usage:"Policy for the containers of the split point, which got no items in a shard: 'none' keeps them, e.g. a group with 'rules: []', 'parent' removes them, 'ancestors' removes them along with their ancestors left empty." env:"YP_PRUNE_EMPTY"`
+	// Routing file with the rules forcing items onto specific shards.
+	RoutesFile *string `mapstructure:"routes,omitempty" usage:"Routing file with the rules forcing the items matching a selector onto specific shards, e.g. 'labels.team=payments' onto 'instance.3'. The items not matching any rule are placed by consistent hashing." env:"YP_ROUTES"`
+	// Remove the placement directives from the comments of the items in the shards.
+	StripDirectives *bool `mapstructure:"strip-directives,omitempty" usage:"Remove the placement directives, e.g. '# yp:pin=instance.2', from the comments of the items in the shards. The other comments are kept." env:"YP_STRIP_DIRECTIVES"`
+	// Paths or patterns of input YAML files that need to be partitioned.
+	// The fields without tags are not handled by SnakeCharmer,
+	// since their flags are repeatable, see cmd/root.go.
+	SrcPaths []string
+	// Patterns of input YAML files to exclude.
+	Excludes []string
+	// Patterns of files copied to all shards as is.
+	CopyToAll []string
+	// Selectors of the items removed from every shard.
+	Drop []string
+	// Selectors of the items kept on every shard.
+	Broadcast []string
+	// Overrides of the replication factor for the matching items.
+	ReplicationOverrides []string
+	// File with the list of input YAML files, "-" means stdin.
+	FilesFrom *string `mapstructure:"files-from,omitempty" usage:"File with the list of input YAML files, one per line. '-' reads the list from stdin." env:"YP_FILES_FROM"`
 	// Output directory where partitioned YAML files are stored.
 	DstDirPath *string `mapstructure:"dst,omitempty" usage:"Output directory where partitioned YAML files are stored." env:"YP_DST_PATH"`
 	// Basename that used for automatic creation of the list of unnamed shards.
@@ -68,21 +130,223 @@ type Config struct {
 	ShardID *int `mapstructure:"shard-id,omitempty" usage:"This shard ID. This represents the index of this instance in the list of shards. If not set (-1), *yp* writes content for all instances."  env:"YP_SHARD_ID"`
 	// Replication Factor. This defines how many shards get the same item.
 	ReplicationFactor *int `mapstructure:"replication,omitempty" usage:"Replication Factor. This defines how many shards get the same YAML item." env:"YP_REPLICATION_FACTOR"`
+	// Consistent hashing algorithm, either "hrw" or "jump".
+	Algorithm *string `mapstructure:"algorithm,omitempty" usage:"Consistent hashing algorithm: 'hrw' (rendezvous hashing) or 'jump' (jump consistent hashing)." env:"YP_ALGORITHM"`
+	// Seed mixed into the consistent hashing to deliberately reshuffle the items.
+	HashSeed *string `mapstructure:"hash-seed,omitempty" usage:"Seed mixed into the hashing of the items and the shards, e.g. '2024-06'. Changing it deliberately reshuffles the items over the same shards, e.g. to get rid of a hot spot. All instances must use the same seed. If not set, the placement is the same as before the seed was introduced." env:"YP_HASH_SEED"`
+	// Max allowed deviation of a shard load from the mean load, e.g. 0.25 means 25%.
+	MaxSkew *float64 `mapstructure:"max-skew,omitempty" usage:"Max allowed deviation of a shard load from the mean load, e.g. 0.25 means 25%. *yp* fails with exit code 3 if any shard exceeds it. If not set (0), the skew is not checked." env:"YP_MAX_SKEW"`
+	// Shard load measure for the skew check, either "items" or "bytes".
+	SkewBy *string `mapstructure:"skew-by,omitempty" usage:"Shard load measure for the skew check: 'items' or 'bytes'." env:"YP_SKEW_BY"`
+	// How many input files are partitioned concurrently.
+	Parallelism *int `mapstructure:"parallelism,omitempty" usage:"How many input files are partitioned concurrently. If not set (0), this is the number of CPUs." env:"YP_PARALLELISM"`
+	// Max duration of partitioning of a single input file, e.g. "30s".
+	FileTimeout *string `mapstructure:"file-timeout,omitempty" usage:"Max duration of partitioning of a single input file, e.g. '30s' or '2m'. '0s' means no deadline." env:"YP_FILE_TIMEOUT"`
+	// Max duration of partitioning of all input files, e.g. "5m".
+	TotalTimeout *string `mapstructure:"total-timeout,omitempty" usage:"Max duration of partitioning of all input files, e.g. '5m'. '0s' means no deadline." env:"YP_TOTAL_TIMEOUT"`
+	// Stop on the first failure and publish nothing.
+	FailFast *bool `mapstructure:"fail-fast,omitempty" usage:"Cancel the remaining files on the first failure and publish nothing." env:"YP_FAIL_FAST"`
+	// Partition all files regardless of failures. This is the default.
+	KeepGoing *bool `mapstructure:"keep-going,omitempty" usage:"Partition all files regardless of failures, report all of them and publish the files partitioned successfully. This is the default unless --fail-fast is set." env:"YP_KEEP_GOING"`
+	// How many generations of the result to keep in the output directory.
+	Generations *int `mapstructure:"generations,omitempty" usage:"How many generations of the result to keep in the output directory. If set, the result is published as <dst>/generations/<id> only if the whole run succeeds, and the <dst>/current symlink is switched to it atomically. If not set (0), the result is copied into the output directory directly." env:"YP_GENERATIONS"`
+	// Remove the output files produced by the previous runs but not by this one.
+	Prune *bool `mapstructure:"prune,omitempty" usage:"Remove the files in the output directory produced by the previous runs but not by this one, e.g. the outputs of deleted input files. The produced files are tracked in the '.yp-state.json' file, so other files are never removed. Nothing is removed if the run fails. This has no effect with --generations." env:"YP_PRUNE"`
+	// Exit with code 2 if any output file is added, changed or removed.
+	ExitCodeOnChange *bool `mapstructure:"exit-code,omitempty" usage:"Exit with code 2 if any output file is added, changed or removed, and with code 0 if nothing changed." env:"YP_EXIT_CODE"`
+}
+
+const (
+	skewByItems = "items"
+	skewByBytes = "bytes"
+)
+
+// parallelism returns how many input files are partitioned concurrently.
+func (c *Config) parallelism() int {
+	if *c.Parallelism > 0 {
+		return *c.Parallelism
+	}
+
+	return runtime.NumCPU()
+}
+
+// fileTimeout returns the max duration of partitioning of a single input file.
+func (c *Config) fileTimeout() (time.Duration, error) {
+	return parseTimeout("file-timeout", *c.FileTimeout)
+}
+
+// totalTimeout returns the max duration of partitioning of all input files.
+func (c *Config) totalTimeout() (time.Duration, error) {
+	return parseTimeout("total-timeout", *c.TotalTimeout)
+}
+
+func parseTimeout(name, s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s: %q must not be negative", name, s)
+	}
+
+	return d, nil
 }
 
 // ConsistentHashing generates list of node names and creates
-// a new Rendezvous that implements partitioner.ConsistentHashing interface.
-func (c *Config) ConsistentHashing() partitioner.ConsistentHashing {
+// a new consistent hashing that implements partitioner.ConsistentHashing interface.
+func (c *Config) ConsistentHashing() (partitioner.ConsistentHashing, error) {
 	if consistentHashing != nil {
-		return consistentHashing
+		return consistentHashing, nil
 	}
 
-	shardNames := make([]string, *c.ShardsNumber)
+	h, err := newConsistentHashing(*c.Algorithm, c.shardNames(*c.ShardsNumber), *c.HashSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	consistentHashing = h
+
+	return consistentHashing, nil
+}
+
+// shardNames generates the list of n unnamed shards.
+func (c *Config) shardNames(n int) []string {
+	shardNames := make([]string, n)
 	for i := 0; i < len(shardNames); i++ {
 		shardNames[i] = fmt.Sprintf("%s.%d", *c.ShardBaseName, i)
 	}
 
-	consistentHashing, _ = hrw.New(xxhash.Sum64, shardNames...)
+	return shardNames
+}
+
+const (
+	algorithmHRW  = "hrw"
+	algorithmJump = "jump"
+)
+
+// newConsistentHashing creates a new consistent hashing
+// of the given algorithm over the given nodes,
+// the seed is mixed into the hashing, see hrw.Seeded.
+func newConsistentHashing(algorithm string, nodes []string, seed string) (partitioner.ConsistentHashing, error) {
+	var (
+		h   partitioner.ConsistentHashing
+		err error
+	)
+
+	switch algorithm {
+	case algorithmHRW:
+		h, err = hrw.New(hrw.Seeded(xxhash.Sum64, seed), nodes...)
+	case algorithmJump:
+		h, err = jump.New(jump.Hasher(hrw.Seeded(xxhash.Sum64, seed)), nodes...)
+	default:
+		err = fmt.Errorf("unknown consistent hashing algorithm: %q", algorithm)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// routes reads the routing file, if any.
+func (c *Config) routes() ([]*partitioner.Route, error) {
+	if len(*c.RoutesFile) == 0 {
+		return nil, nil
+	}
+
+	input, err := os.ReadFile(*c.RoutesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing file: %w", err)
+	}
+
+	routes, err := partitioner.ParseRoutes(input)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing file %q: %w", *c.RoutesFile, err)
+	}
+
+	return routes, nil
+}
+
+// selectors parses the selectors of the given flag, if any.
+func selectors(flag string, values []string) ([]*partitioner.Selector, error) {
+	selectors := make([]*partitioner.Selector, 0, len(values))
+
+	for _, s := range values {
+		sel, err := partitioner.ParseSelector(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s selector %q: %w", flag, s, err)
+		}
+
+		selectors = append(selectors, sel)
+	}
+
+	return selectors, nil
+}
+
+// replicationOverrides parses the overrides of the replication factor, if any.
+func (c *Config) replicationOverrides() ([]*partitioner.ReplicationOverride, error) {
+	overrides := make([]*partitioner.ReplicationOverride, 0, len(c.ReplicationOverrides))
+
+	for _, s := range c.ReplicationOverrides {
+		o, err := partitioner.ParseReplicationOverride(s)
+		if err != nil {
+			return nil, err
+		}
+
+		overrides = append(overrides, o)
+	}
+
+	return overrides, nil
+}
+
+// partitionerOptions returns the partitioner options
+// that are common for all *yp* commands.
+func (c *Config) partitionerOptions() ([]partitioner.Option, error) {
+	h, err := c.ConsistentHashing()
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := c.routes()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := inputRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	drop, err := selectors("drop", c.Drop)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcast, err := selectors("broadcast", c.Broadcast)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := c.replicationOverrides()
+	if err != nil {
+		return nil, err
+	}
 
-	return consistentHashing
+	return []partitioner.Option{
+		partitioner.WithConsistentHashing(h),
+		partitioner.WithReplicasCount(*c.ReplicationFactor),
+		partitioner.WithSplitPoint(*c.SplitPointPath),
+		partitioner.WithFormat(*c.Format),
+		partitioner.WithMergeKeys(*c.MergeKeys),
+		partitioner.WithPruneEmpty(*c.PruneEmpty),
+		partitioner.WithInputRoot(root),
+		partitioner.WithDrop(drop...),
+		partitioner.WithRoutes(routes...),
+		partitioner.WithBroadcast(broadcast...),
+		partitioner.WithReplicationOverrides(overrides...),
+		partitioner.WithStripDirectives(*c.StripDirectives),
+		partitioner.WithThisShardID(*c.ShardID),
+	}, nil
 }
@@ -0,0 +1,61 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Exit codes of *yp*.
+const (
+	// ExitCodeError means *yp* failed.
+	ExitCodeError = 1
+	// ExitCodeChanged means any output file is added, changed or removed, see --exit-code.
+	ExitCodeChanged = 2
+	// ExitCodeSkew means the shards are unbalanced, see --max-skew.
+	ExitCodeSkew = 3
+)
+
+// SkewError is returned when the load of some shards
+// deviates from the mean load more than allowed.
+type SkewError struct {
+	// Shards is the skew of each offending shard.
+	Shards map[string]float64
+	// By is the shard load measure, either "items" or "bytes".
+	By      string
+	MaxSkew float64
+}
+
+// Error implements the error interface.
+func (e *SkewError) Error() string {
+	names := make([]string, 0, len(e.Shards))
+	for name := range e.Shards {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%q %+.1f%%", name, e.Shards[name]*100)
+	}
+
+	return fmt.Sprintf("%d shard(s) deviate from the mean %s count more than %.1f%%: %s",
+		len(e.Shards), e.By, e.MaxSkew*100, strings.Join(names, ", "))
+}
+
+// ExitCode returns the exit code of *yp*.
+func (e *SkewError) ExitCode() int { return ExitCodeSkew }
@@ -0,0 +1,328 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/asokolov365/YamlPartitioner/lib/filesutil"
+)
+
+// defaultSrcPath is used if neither --src nor --files-from is set.
+const defaultSrcPath = "./**/*.{yml,yaml}"
+
+// listInputFiles returns the sorted list of the input files matching --src
+// or read from --files-from, which are not excluded with --exclude or
+// the .ypignore files found in the current directory and in the base
+// directory of each --src pattern, along with the sorted list of
+// the companion files matching --copy-to-all. The companion files
+// are copied to all shards as is, so they are not partitioned.
+func listInputFiles() (inputFiles, companionFiles []string, err error) {
+	srcPaths := MainConfig.SrcPaths
+	if len(srcPaths) == 0 && len(*MainConfig.FilesFrom) == 0 {
+		srcPaths = []string{defaultSrcPath}
+	}
+
+	excluder, err := newExcluder(srcPaths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	companionFiles, err = filesutil.ListAll(MainConfig.CopyToAll, excluder)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	inputFiles, err = filesutil.ListAll(srcPaths, excluder)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	if len(*MainConfig.FilesFrom) > 0 {
+		listed, err := readFilesFrom(*MainConfig.FilesFrom, excluder)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		inputFiles = uniqueSorted(append(inputFiles, listed...))
+	}
+
+	inputFiles = without(inputFiles, companionFiles)
+
+	if len(inputFiles) < 1 {
+		return nil, nil, fmt.Errorf("no file(s) found for %s", describeSources(srcPaths))
+	}
+
+	return inputFiles, companionFiles, nil
+}
+
+// commonPath returns the longest common path of the input files
+// and the companion files. The output files are relative to it.
+func commonPath(inputFiles, companionFiles []string) string {
+	allFiles := make([]string, 0, len(inputFiles)+len(companionFiles))
+	allFiles = append(allFiles, inputFiles...)
+	allFiles = append(allFiles, companionFiles...)
+
+	return filesutil.LongestCommonPath(allFiles)
+}
+
+// inputRoot returns the longest common directory of the base
+// directories of the --src patterns, and of the current directory
+// if --files-from is set. The consistent hashing keys of the input files
+// are relative to it for the @file split point, so unlike commonPath
+// it does not change when an input file is added or removed.
+func inputRoot() (string, error) {
+	roots, err := srcRoots()
+	if err != nil {
+		return "", err
+	}
+
+	return filesutil.LongestCommonPath(roots), nil
+}
+
+// srcRoots returns the absolute base directories of the --src patterns,
+// and the current directory if --files-from is set, each with
+// the trailing separator, so they keep the last directory in the common path.
+func srcRoots() ([]string, error) {
+	srcPaths := MainConfig.SrcPaths
+	if len(srcPaths) == 0 && len(*MainConfig.FilesFrom) == 0 {
+		srcPaths = []string{defaultSrcPath}
+	}
+
+	dirs := make([]string, 0, len(srcPaths)+1)
+	for _, srcPath := range srcPaths {
+		dirs = append(dirs, filesutil.PatternBase(srcPath))
+	}
+
+	// The listed files are taken relative to the current directory.
+	if len(*MainConfig.FilesFrom) > 0 {
+		dirs = append(dirs, ".")
+	}
+
+	return absDirs(dirs)
+}
+
+// absDirs returns the absolute paths of the dirs with the trailing separator.
+func absDirs(dirs []string) ([]string, error) {
+	res := make([]string, 0, len(dirs))
+
+	for _, dir := range dirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %q: %w", dir, err)
+		}
+
+		res = append(res, absDir+string(filepath.Separator))
+	}
+
+	return res, nil
+}
+
+func newExcluder(srcPaths []string) (*filesutil.Excluder, error) {
+	excluder, err := filesutil.NewExcluder(MainConfig.Excludes...)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0, len(srcPaths)+1)
+	dirs = append(dirs, ".")
+
+	for _, srcPath := range srcPaths {
+		dirs = append(dirs, filesutil.PatternBase(srcPath))
+	}
+
+	seen := make(map[string]struct{}, len(dirs))
+
+	for _, dir := range dirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %q: %w", dir, err)
+		}
+
+		if _, ok := seen[absDir]; ok {
+			continue
+		}
+
+		seen[absDir] = struct{}{}
+
+		if err := excluder.AddIgnoreFile(absDir); err != nil {
+			return nil, err
+		}
+	}
+
+	return excluder, nil
+}
+
+// readFilesFrom reads the list of input files from the file
+// or from stdin if name is "-". The paths are taken literally,
+// empty lines and lines starting with "#" are skipped.
+func readFilesFrom(name string, excluder *filesutil.Excluder) ([]string, error) {
+	var r io.Reader = os.Stdin
+
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open files list: %w", err)
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	var files []string
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		file, err := filepath.Abs(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %q: %w", line, err)
+		}
+
+		fileInfo, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input file in the files list: %w", err)
+		}
+
+		if !fileInfo.Mode().IsRegular() {
+			return nil, fmt.Errorf("invalid input file in the files list: %q is not a regular file", line)
+		}
+
+		if excluder.Excluded(file) {
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read files list: %w", err)
+	}
+
+	return files, nil
+}
+
+func uniqueSorted(files []string) []string {
+	sort.Strings(files)
+
+	res := files[:0]
+
+	for i, file := range files {
+		if i > 0 && file == files[i-1] {
+			continue
+		}
+
+		res = append(res, file)
+	}
+
+	return res
+}
+
+// without returns the files that are not in the sorted list of excluded files.
+func without(files, excluded []string) []string {
+	if len(excluded) == 0 {
+		return files
+	}
+
+	res := make([]string, 0, len(files))
+
+	for _, file := range files {
+		i := sort.SearchStrings(excluded, file)
+		if i < len(excluded) && excluded[i] == file {
+			continue
+		}
+
+		res = append(res, file)
+	}
+
+	return res
+}
+
+func describeSources(srcPaths []string) string {
+	sources := make([]string, 0, len(srcPaths)+1)
+	for _, srcPath := range srcPaths {
+		sources = append(sources, fmt.Sprintf("pattern %q", srcPath))
+	}
+
+	if len(*MainConfig.FilesFrom) > 0 {
+		sources = append(sources, fmt.Sprintf("files list %q", *MainConfig.FilesFrom))
+	}
+
+	return strings.Join(sources, ", ")
+}
+
+// output is an input file along with its output file
+// relative to the shard directory.
+type output struct {
+	inputFile  string
+	outputFile string
+}
+
+// caseInsensitiveFS is true on the platforms, which file systems
+// are case-insensitive by default, e.g. "Rules.yml" is "rules.yml" there.
+var caseInsensitiveFS = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// checkCollisions returns an error if different input files have
+// the same path relative to their roots, i.e. to the base directories
+// of the --src and --copy-to-all patterns (see filesutil.Collisions).
+// Their output files only differ by the names of the roots,
+// e.g. "team-a/rules.yml" and "team-b/rules.yml", so the output layout
+// would depend on which roots are given. On the case-insensitive file systems
+// the paths differing in case only collide as well, see caseInsensitiveFS.
+func checkCollisions(outputs []output) error {
+	roots, err := srcRoots()
+	if err != nil {
+		return err
+	}
+
+	companionRoots := make([]string, 0, len(MainConfig.CopyToAll))
+	for _, pattern := range MainConfig.CopyToAll {
+		companionRoots = append(companionRoots, filesutil.PatternBase(pattern))
+	}
+
+	if companionRoots, err = absDirs(companionRoots); err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(outputs))
+	for _, o := range outputs {
+		files = append(files, o.inputFile)
+	}
+
+	found := filesutil.Collisions(files, append(roots, companionRoots...), caseInsensitiveFS)
+	if len(found) == 0 {
+		return nil
+	}
+
+	collisions := make([]string, 0, len(found))
+	for _, c := range found {
+		collisions = append(collisions, fmt.Sprintf("[!] %q and %q are both %q relative to their source roots",
+			c.First, c.Second, c.Path))
+	}
+
+	return fmt.Errorf("%d output file collision(s):\n%s", len(collisions), strings.Join(collisions, "\n"))
+}
@@ -0,0 +1,172 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/asokolov365/YamlPartitioner/lib/partitioner"
+	"gopkg.in/yaml.v3"
+)
+
+// scorer is implemented by ConsistentHashing algorithms
+// that can explain their decisions, e.g. hrw.Rendezvous.
+type scorer interface {
+	Score(key []byte, node string) (uint64, bool)
+}
+
+// LocateItem reads a YAML item from r and prints the ordered list
+// of shards that get it along with the score of each shard.
+// If verbose is true, the scores of all shards are printed as well.
+// The input files tell if the split point is a MappingNode,
+// so the item must be given as a key-value pair, see partitioner.Config.LocateItem.
+func LocateItem(ctx context.Context, w io.Writer, r io.Reader, verbose bool) error {
+	cfg, err := newConfig()
+	if err != nil {
+		return err
+	}
+
+	inputFiles, _, err := listInputFiles()
+	if err != nil {
+		return err
+	}
+
+	kind, err := cfg.SplitPointKind(ctx, inputFiles...)
+	if err != nil {
+		return err
+	}
+
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read yaml item: %w", err)
+	}
+
+	loc, err := cfg.LocateItem(input, kind == yaml.MappingNode)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case loc.Dropped:
+		fmt.Fprintln(w, "Item is dropped, it matches a drop selector.")
+		return nil
+	case len(loc.Owners) == 0:
+		fmt.Fprintln(w, "Item goes to no shards, it is skipped by a directive.")
+		return nil
+	case loc.Broadcast:
+		fmt.Fprintf(w, "Item is broadcast to all %d shards:\n", cfg.NodesCount())
+	case loc.ReplicasCount > 0:
+		fmt.Fprintf(w, "Item goes to %d of %d shards with RF=%d:\n",
+			len(loc.Owners), cfg.NodesCount(), loc.ReplicasCount)
+	default:
+		// The item is placed by a route or a directive, e.g. "yp:pin".
+		fmt.Fprintf(w, "Item goes to %d of %d shards regardless of consistent hashing:\n",
+			len(loc.Owners), cfg.NodesCount())
+	}
+
+	for i, name := range loc.Owners {
+		fmt.Fprintf(w, "%d. %q %s\n", i+1, name, score(loc.Key, name))
+	}
+
+	if verbose {
+		fmt.Fprintln(w, "Scores of all shards:")
+
+		for _, name := range cfg.NodeNames() {
+			fmt.Fprintf(w, "   %q %s\n", name, score(loc.Key, name))
+		}
+	}
+
+	return nil
+}
+
+// LocateValue searches the input files for the items at the split point
+// that contain value (see partitioner.MatchValue), and prints the file,
+// the path and the ordered list of shards that get each matching item.
+func LocateValue(ctx context.Context, w io.Writer, field, value string) error {
+	inputFiles, companionFiles, err := listInputFiles()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := newConfig()
+	if err != nil {
+		return err
+	}
+
+	var (
+		match = partitioner.MatchValue(field, value)
+		found int
+	)
+
+	commonPath := commonPath(inputFiles, companionFiles)
+
+	for _, file := range inputFiles {
+		p, err := partitioner.WithConfig(cfg, file, commonPath)
+		if err != nil {
+			return fmt.Errorf("failed to init partitioner instance: %w", err)
+		}
+
+		locations, err := p.Locate(ctx, match)
+		if err != nil {
+			return err
+		}
+
+		for _, loc := range locations {
+			owners := make([]string, len(loc.Owners))
+			for i, name := range loc.Owners {
+				owners[i] = fmt.Sprintf("%q %s", name, score(loc.Key, name))
+			}
+
+			fmt.Fprintf(w, "%s:%d %s => %s\n", file, loc.Line, loc.Path, strings.Join(owners, ", "))
+		}
+
+		found += len(locations)
+	}
+
+	if found == 0 {
+		return fmt.Errorf("no items matching %q found at path %q", value, *MainConfig.SplitPointPath)
+	}
+
+	return nil
+}
+
+// newConfig creates a new partitioner config for the commands
+// that do not write any files, e.g. locate or simulate.
+func newConfig() (*partitioner.Config, error) {
+	opts, err := MainConfig.partitionerOptions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to init partitioner config: %w", err)
+	}
+
+	cfg, err := partitioner.NewConfig(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init partitioner config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func score(key []byte, name string) string {
+	if s, ok := consistentHashing.(scorer); ok {
+		if v, ok := s.Score(key, name); ok {
+			return fmt.Sprintf("(score %d)", v)
+		}
+	}
+
+	return "(score n/a)"
+}
@@ -0,0 +1,283 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/asokolov365/YamlPartitioner/lib/balance"
+	"github.com/asokolov365/YamlPartitioner/lib/partitioner"
+)
+
+// Simulation defines the layouts that Simulate sweeps.
+// Empty lists default to the current layout.
+type Simulation struct {
+	ShardsNumbers      []int
+	ReplicationFactors []int
+	Algorithms         []string
+	// Format is the output format, either "table" or "csv".
+	Format string
+}
+
+// Simulate partitions the input files in memory over every combination
+// of the shards numbers, replication factors and algorithms of sim,
+// and prints the balance statistics of each layout along with
+// the movement cost relative to the current layout.
+// The items are placed as for a run, e.g. by the routes and the directives.
+// Combinations that are not valid, e.g. with a too big replication factor,
+// are skipped and reported after the layouts.
+func Simulate(ctx context.Context, w io.Writer, sim Simulation) error {
+	if len(sim.ShardsNumbers) == 0 {
+		sim.ShardsNumbers = []int{*MainConfig.ShardsNumber}
+	}
+
+	if len(sim.ReplicationFactors) == 0 {
+		sim.ReplicationFactors = []int{*MainConfig.ReplicationFactor}
+	}
+
+	if len(sim.Algorithms) == 0 {
+		sim.Algorithms = []string{*MainConfig.Algorithm}
+	}
+
+	inputFiles, companionFiles, err := listInputFiles()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := newConfig()
+	if err != nil {
+		return err
+	}
+
+	commonPath := commonPath(inputFiles, companionFiles)
+
+	current, err := locateAll(ctx, cfg, inputFiles, commonPath)
+	if err != nil {
+		return err
+	}
+
+	items := make([]balance.Item, 0, len(current))
+	currentOwners := make([][]string, 0, len(current))
+
+	for _, loc := range current {
+		if simulated(loc) {
+			items = append(items, balance.Item{Key: loc.Key, Size: loc.Size})
+			currentOwners = append(currentOwners, loc.Owners)
+		}
+	}
+
+	var (
+		layouts []simulatedLayout
+		skipped []string
+	)
+
+	for _, algorithm := range sim.Algorithms {
+		for _, n := range sim.ShardsNumbers {
+			h, err := newConsistentHashing(algorithm, MainConfig.shardNames(n), *MainConfig.HashSeed)
+			if err != nil {
+				return err
+			}
+
+			for _, rf := range sim.ReplicationFactors {
+				opts, err := MainConfig.partitionerOptions()
+				if err != nil {
+					return err
+				}
+
+				// The layout replaces the current consistent hashing and replication factor,
+				// the rest of the options, e.g. the routes, place the items as for a run.
+				c, err := partitioner.NewConfig(append(opts,
+					partitioner.WithConsistentHashing(h),
+					partitioner.WithReplicasCount(rf),
+				)...)
+				if err != nil {
+					skipped = append(skipped, fmt.Sprintf("Skipped %s with %d shards and RF=%d: %v", algorithm, n, rf, err))
+					continue
+				}
+
+				// The items may refer to the shards missing in the layout, e.g. with "yp:pin".
+				locations, err := locateAll(ctx, c, inputFiles, commonPath)
+				if err != nil {
+					skipped = append(skipped, fmt.Sprintf("Skipped %s with %d shards and RF=%d: %v", algorithm, n, rf, err))
+					continue
+				}
+
+				owners := make([][]string, 0, len(items))
+
+				for _, loc := range locations {
+					if simulated(loc) {
+						owners = append(owners, loc.Owners)
+					}
+				}
+
+				layouts = append(layouts, simulatedLayout{
+					algorithm: algorithm,
+					shards:    n,
+					rf:        rf,
+					current: algorithm == *MainConfig.Algorithm && n == *MainConfig.ShardsNumber &&
+						rf == *MainConfig.ReplicationFactor,
+					res: balance.Distribute(items, h.NodeNames(), owners, currentOwners),
+				})
+			}
+		}
+	}
+
+	// The skipped layouts go to stderr, so the csv output stays valid.
+	skippedOutput := w
+	if sim.Format == "csv" {
+		skippedOutput = os.Stderr
+	}
+
+	defer func() {
+		for _, line := range skipped {
+			fmt.Fprintln(skippedOutput, line)
+		}
+	}()
+
+	if len(layouts) == 0 {
+		return fmt.Errorf("no valid layouts to simulate")
+	}
+
+	switch sim.Format {
+	case "csv":
+		cw := csv.NewWriter(w)
+
+		if err := cw.Write(simulateHeader); err != nil {
+			return fmt.Errorf("failed to write csv: %w", err)
+		}
+
+		for _, l := range layouts {
+			if err := cw.Write(l.row(csvFormat)); err != nil {
+				return fmt.Errorf("failed to write csv: %w", err)
+			}
+		}
+
+		cw.Flush()
+
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("failed to write csv: %w", err)
+		}
+
+	case "table", "":
+		fmt.Fprintf(w, "Simulated partitioning of %d items from %d yaml files\n", len(items), len(inputFiles))
+
+		if len(*MainConfig.HashSeed) > 0 {
+			fmt.Fprintf(w, "Hash seed %q\n", *MainConfig.HashSeed)
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+		writeRow(tw, simulateHeader)
+
+		for _, l := range layouts {
+			writeRow(tw, l.row(tableFormat))
+		}
+
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("failed to write table: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("unknown output format: %q", sim.Format)
+	}
+
+	return nil
+}
+
+// locateAll locates all items of the input files with the config.
+func locateAll(ctx context.Context, cfg *partitioner.Config, inputFiles []string, commonPath string) ([]*partitioner.Location, error) {
+	var locations []*partitioner.Location
+
+	for _, file := range inputFiles {
+		p, err := partitioner.WithConfig(cfg, file, commonPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init partitioner instance: %w", err)
+		}
+
+		locs, err := p.Locate(ctx, partitioner.MatchAll)
+		if err != nil {
+			return nil, err
+		}
+
+		locations = append(locations, locs...)
+	}
+
+	return locations, nil
+}
+
+// simulated reports whether the item counts for the balance of a layout.
+// The broadcast items are the same for every layout,
+// and the dropped items go nowhere.
+func simulated(loc *partitioner.Location) bool {
+	return !loc.Broadcast && !loc.Dropped
+}
+
+var simulateHeader = []string{
+	"algorithm", "shards", "rf",
+	"items_min", "items_max", "items_stddev", "items_cv",
+	"bytes_min", "bytes_max", "bytes_stddev", "bytes_cv",
+	"moved", "current",
+}
+
+type simulatedLayout struct {
+	algorithm string
+	res       balance.Result
+	shards    int
+	rf        int
+	current   bool
+}
+
+// row returns the layout as a row of cells formatted with formatFloat.
+func (l *simulatedLayout) row(formatFloat func(f float64, prec int) string) []string {
+	current := ""
+	if l.current {
+		current = "*"
+	}
+
+	return []string{
+		l.algorithm, strconv.Itoa(l.shards), strconv.Itoa(l.rf),
+		formatFloat(l.res.Items.Min, 0), formatFloat(l.res.Items.Max, 0),
+		formatFloat(l.res.Items.StdDev, 2), formatFloat(l.res.Items.CV, 3),
+		formatFloat(l.res.Bytes.Min, 0), formatFloat(l.res.Bytes.Max, 0),
+		formatFloat(l.res.Bytes.StdDev, 2), formatFloat(l.res.Bytes.CV, 3),
+		formatFloat(l.res.Moved, 3), current,
+	}
+}
+
+func tableFormat(f float64, prec int) string {
+	return strconv.FormatFloat(f, 'f', prec, 64)
+}
+
+func csvFormat(f float64, _ int) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func writeRow(w io.Writer, row []string) {
+	for i, cell := range row {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+
+		fmt.Fprint(w, cell)
+	}
+
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,72 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/asokolov365/YamlPartitioner/app"
+	"github.com/spf13/cobra"
+)
+
+// locateCmd represents the locate command
+var locateCmd = &cobra.Command{
+	Use:   "locate [value]",
+	Short: "Explains which shards get a YAML item.",
+	Long: `Explains which shards get a YAML item.
+Without arguments it reads a single YAML item from stdin and prints
+the ordered list of shards that get it along with their rendezvous scores.
+If the split point of the input files is a mapping, the item must be
+a single key-value pair, e.g. a blackbox module along with its name.
+With a value it searches the input files for the items at the split point
+that contain the value, and prints the file, the path and the shards of each item.`,
+	Example: `# Which instances evaluate the alert "KubePodCrashLooping"?
+> yp locate --src="./rules/**/*.yml" \
+  --split-at="groups.*.rules" \
+  --shards-number=5 \
+  --replication=2 \
+  --field=alert KubePodCrashLooping
+
+# Which instances get the blackbox module read from stdin?
+> printf 'http_2xx:\n  prober: http\n' | yp locate --src="./blackbox.yml" \
+  --split-at="modules" \
+  --shards-number=5`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return unmarshalConfig(cmd, partitioningFlags...)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Create a context that cancels when OS signals come in.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+		defer stop()
+
+		if len(args) == 0 {
+			return app.LocateItem(ctx, os.Stdout, os.Stdin, verbose)
+		}
+
+		return app.LocateValue(ctx, os.Stdout, locateField, args[0])
+	},
+}
+
+var locateField string
+
+func init() {
+	locateCmd.Flags().StringVar(&locateField, "field", "",
+		"Compare the value with the values of this field only. By default all scalars of an item are compared.")
+	rootCmd.AddCommand(locateCmd)
+}
@@ -0,0 +1,54 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/asokolov365/YamlPartitioner/app"
+	"github.com/spf13/cobra"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [generation]",
+	Short: "Switches the output directory back to a previous generation.",
+	Long: `Switches the <dst>/current symlink back to the given generation,
+or to the one preceding the current generation if none is given.
+This requires the result to be published as generations, see --generations.
+The next successful run publishes a new generation and makes it current again.`,
+	Example: `# Roll back to the previous generation
+> yp rollback --dst="/etc/prometheus/rules" --generations=5
+
+# Roll back to the given generation
+> yp rollback --dst="/etc/prometheus/rules" --generations=5 20240115T101500.000000000Z`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return unmarshalConfig(cmd)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var id string
+		if len(args) > 0 {
+			id = args[0]
+		}
+
+		return app.Rollback(os.Stdout, id)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+}
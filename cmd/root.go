@@ -17,14 +17,19 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/asokolov365/YamlPartitioner/app"
 	"github.com/asokolov365/YamlPartitioner/version"
 	"github.com/asokolov365/snakecharmer"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -54,12 +59,7 @@ var rootCmd = &cobra.Command{
 	// in fact, a result of a bad invocation, e.g. too many arguments.
 	SilenceUsage: true,
 	PreRunE: func(cmd *cobra.Command, args []string) (err error) {
-		// cmd.DebugFlags()
-		// This fills out the Config struct.
-		if err = charmer.UnmarshalExact(); err != nil {
-			if errUsage := cmd.Usage(); errUsage != nil {
-				fmt.Fprintf(os.Stderr, "Error: %s\n", errUsage.Error())
-			}
+		if err = unmarshalConfig(cmd, partitioningFlags...); err != nil {
 			return err
 		}
 
@@ -75,10 +75,15 @@ var rootCmd = &cobra.Command{
 		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 		defer stop()
 
-		if err := app.Run(ctx, verbose); err != nil {
+		changed, err := app.Run(ctx, verbose)
+		if err != nil {
 			return err
 		}
 
+		if changed && *app.MainConfig.ExitCodeOnChange {
+			exitCode = app.ExitCodeChanged
+		}
+
 		return nil
 	},
 
@@ -89,15 +94,48 @@ var rootCmd = &cobra.Command{
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() int {
 	if err := rootCmd.Execute(); err != nil {
-		return 1
+		var exitCoder interface{ ExitCode() int }
+		if errors.As(err, &exitCoder) {
+			return exitCoder.ExitCode()
+		}
+
+		return app.ExitCodeError
 	}
-	return 0
+	return exitCode
+}
+
+// partitioningFlags are required by the commands that partition the input,
+// but not by the ones that only manage the output, e.g. rollback.
+var partitioningFlags = []string{"split-at", "shards-number"}
+
+// unmarshalConfig checks the required flags are set and fills out
+// the app.MainConfig struct with the values from flags and ENV vars.
+func unmarshalConfig(cmd *cobra.Command, required ...string) error {
+	// cmd.DebugFlags()
+	if err := requireFlags(cmd, required...); err != nil {
+		return err
+	}
+
+	if err := setRepeatableFlagsFromEnv(cmd); err != nil {
+		return err
+	}
+
+	if err := charmer.UnmarshalExact(); err != nil {
+		if errUsage := cmd.Usage(); errUsage != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", errUsage.Error())
+		}
+		return err
+	}
+
+	return nil
 }
 
 var (
 	vpr     *viper.Viper
 	charmer *snakecharmer.SnakeCharmer
 	verbose bool
+	// exitCode is the exit code of a successful run.
+	exitCode int
 )
 
 func init() {
@@ -112,6 +150,8 @@ func init() {
 		snakecharmer.WithCobraCommand(rootCmd),
 		snakecharmer.WithViper(vpr),
 		snakecharmer.WithResultStruct(app.MainConfig),
+		// The repeatable flags are added below
+		snakecharmer.WithIgnoreUntaggedFields(true),
 	)
 	if err != nil {
 		panic(fmt.Sprintf("error init SnakeCharmer: %s", err.Error()))
@@ -120,12 +160,90 @@ func init() {
 	// This adds Flags automatically generated from the app.MainConfig struct
 	charmer.AddFlags()
 
-	// See config.go for the complete list of the flags
-	// rootCmd.MarkPersistentFlagRequired("src")
-	if err = rootCmd.MarkPersistentFlagRequired("split-at"); err != nil {
-		panic(err)
+	// SnakeCharmer generates StringSlice flags for []string fields, which
+	// split values on commas, so patterns like "*.{yml,yaml}" are broken.
+	rootCmd.PersistentFlags().StringArrayVar(&app.MainConfig.SrcPaths, "src", nil,
+		"Path or pattern of input YAML files that need to be partitioned, e.g. './rules/**/*.yml'. "+
+			"This can be repeated. Defaults to './**/*.{yml,yaml}' unless --files-from is set.")
+	rootCmd.PersistentFlags().StringArrayVar(&app.MainConfig.Excludes, "exclude", nil,
+		"Pattern of input YAML files to exclude, e.g. '*_test.yaml' or './rules/vendor/**'. "+
+			"This can be repeated. The patterns from the .ypignore files are excluded as well.")
+	rootCmd.PersistentFlags().StringArrayVar(&app.MainConfig.CopyToAll, "copy-to-all", nil,
+		"Pattern of files that are copied to every shard as is, e.g. './rules/**/*.tmpl'. "+
+			"The matching files keep the same relative path as the input files and are not partitioned. "+
+			"This can be repeated.")
+	rootCmd.PersistentFlags().StringArrayVar(&app.MainConfig.Drop, "drop", nil,
+		"Selector of the items removed from every shard, e.g. 'labels.env=staging'. "+
+			"The dropped items are not counted as the items found at the split point, and they are listed in the verbose report. "+
+			"This can be repeated.")
+	rootCmd.PersistentFlags().StringArrayVar(&app.MainConfig.ReplicationOverrides, "replication-override", nil,
+		"Replication Factor of the items matching a selector instead of --replication, e.g. 'labels.severity=critical=>3'. "+
+			"The first matching override is used, and the factor must be from 1 to half the number of shards, as for --replication. "+
+			"This can be repeated.")
+	rootCmd.PersistentFlags().StringArrayVar(&app.MainConfig.Broadcast, "broadcast", nil,
+		"Selector of the items kept on every shard regardless of consistent hashing, e.g. 'alert=~Watchdog|Heartbeat'. "+
+			"The broadcast items are counted separately, so they do not affect the balance of the shards. "+
+			"This can be repeated.")
+
+	// See config.go for the complete list of the flags.
+	// The required flags are checked by unmarshalConfig instead of
+	// rootCmd.MarkPersistentFlagRequired, since the persistent flags
+	// marked as required are required by all subcommands.
+}
+
+// repeatableFlags maps the repeatable flags, which are not handled
+// by SnakeCharmer, to their ENV vars. The ENV var holds the list of values
+// separated with the OS path list separator, e.g. "./rules/*.yml:./alerts/*.yml".
+var repeatableFlags = map[string]string{
+	"src":                  "YP_SRC_PATH",
+	"exclude":              "YP_EXCLUDE",
+	"copy-to-all":          "YP_COPY_TO_ALL",
+	"drop":                 "YP_DROP",
+	"broadcast":            "YP_BROADCAST",
+	"replication-override": "YP_REPLICATION_OVERRIDE",
+}
+
+// setRepeatableFlagsFromEnv sets the repeatable flags
+// that are not set on the command line from their ENV vars.
+func setRepeatableFlagsFromEnv(cmd *cobra.Command) error {
+	for name, env := range repeatableFlags {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+
+		value, ok := os.LookupEnv(env)
+		if !ok || len(value) == 0 {
+			continue
+		}
+
+		sliceValue, ok := f.Value.(pflag.SliceValue)
+		if !ok {
+			panic(fmt.Sprintf("BUG: flag %q is not repeatable", name))
+		}
+
+		if err := sliceValue.Replace(filepath.SplitList(value)); err != nil {
+			return fmt.Errorf("invalid %s: %w", env, err)
+		}
 	}
-	if err = rootCmd.MarkPersistentFlagRequired("shards-number"); err != nil {
-		panic(err)
+
+	return nil
+}
+
+// requireFlags returns the same error as cobra does
+// if any of the given flags is not set.
+func requireFlags(cmd *cobra.Command, names ...string) error {
+	var missing []string
+
+	for _, name := range names {
+		if f := cmd.Flags().Lookup(name); f == nil || !f.Changed {
+			missing = append(missing, strconv.Quote(name))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("required flag(s) %s not set", strings.Join(missing, ", "))
 	}
+
+	return nil
 }
@@ -0,0 +1,128 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/asokolov365/YamlPartitioner/app"
+	"github.com/spf13/cobra"
+)
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Simulates partitioning over a range of layouts to choose the shards number.",
+	Long: `Simulates partitioning of the input files in memory over every combination
+of the given shards numbers, replication factors and algorithms.
+For each layout it prints min, max, stddev and coefficient of variation
+of items and bytes per shard, plus the share of item replicas that
+would move comparing to the current layout (see --shards-number, --replication and --algorithm).
+The current layout is marked with "*". The items are placed as for a run,
+e.g. by the routes and the directives. The layouts that are not valid,
+e.g. with a too big replication factor, are listed as skipped.`,
+	Example: `# Which shards number gives the best balance?
+> yp simulate --src="./rules/**/*.yml" \
+  --split-at="groups.*.rules" \
+  --shards-number=5 \
+  --replication=2 \
+  --shards-range=3-10 \
+  --replication-range=1,2 \
+  --algorithms=hrw,jump`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return unmarshalConfig(cmd, partitioningFlags...)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		shardsNumbers, err := parseIntRanges(simulateShards)
+		if err != nil {
+			return fmt.Errorf("invalid --shards-range: %w", err)
+		}
+
+		replicationFactors, err := parseIntRanges(simulateReplication)
+		if err != nil {
+			return fmt.Errorf("invalid --replication-range: %w", err)
+		}
+
+		// Create a context that cancels when OS signals come in.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+		defer stop()
+
+		return app.Simulate(ctx, os.Stdout, app.Simulation{
+			ShardsNumbers:      shardsNumbers,
+			ReplicationFactors: replicationFactors,
+			Algorithms:         simulateAlgorithms,
+			Format:             simulateFormat,
+		})
+	},
+}
+
+var (
+	simulateShards      []string
+	simulateReplication []string
+	simulateAlgorithms  []string
+	simulateFormat      string
+)
+
+func init() {
+	simulateCmd.Flags().StringSliceVar(&simulateShards, "shards-range", nil,
+		"Shards numbers to simulate, e.g. '3-10' or '3,5,8'. Defaults to --shards-number.")
+	simulateCmd.Flags().StringSliceVar(&simulateReplication, "replication-range", nil,
+		"Replication factors to simulate, e.g. '1-3' or '1,2'. Defaults to --replication.")
+	simulateCmd.Flags().StringSliceVar(&simulateAlgorithms, "algorithms", []string{"hrw", "jump"},
+		"Consistent hashing algorithms to simulate.")
+	simulateCmd.Flags().StringVar(&simulateFormat, "format", "table",
+		"Output format: 'table' or 'csv'.")
+	rootCmd.AddCommand(simulateCmd)
+}
+
+// parseIntRanges parses a list of integers and integer ranges,
+// e.g. ["3-5", "8"] => [3, 4, 5, 8].
+func parseIntRanges(list []string) ([]int, error) {
+	res := make([]int, 0, len(list))
+
+	for _, elem := range list {
+		from, to, isRange := strings.Cut(strings.TrimSpace(elem), "-")
+
+		first, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, err
+		}
+
+		last := first
+
+		if isRange {
+			if last, err = strconv.Atoi(to); err != nil {
+				return nil, err
+			}
+		}
+
+		if last < first {
+			return nil, fmt.Errorf("invalid range: %q", elem)
+		}
+
+		for i := first; i <= last; i++ {
+			res = append(res, i)
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package balance implements utility routines for measuring
+// how evenly items are distributed over shards.
+package balance
+
+import (
+	"math"
+)
+
+// Stats describes the distribution of a load over shards.
+type Stats struct {
+	Min    float64
+	Max    float64
+	Mean   float64
+	StdDev float64
+	// CV is the coefficient of variation, i.e. StdDev / Mean.
+	CV float64
+}
+
+// Compute computes Stats of the given loads of shards.
+func Compute(loads []float64) Stats {
+	if len(loads) == 0 {
+		return Stats{}
+	}
+
+	s := Stats{Min: loads[0], Max: loads[0]}
+
+	var sum float64
+
+	for _, l := range loads {
+		sum += l
+
+		if l < s.Min {
+			s.Min = l
+		}
+
+		if l > s.Max {
+			s.Max = l
+		}
+	}
+
+	s.Mean = sum / float64(len(loads))
+
+	var variance float64
+
+	for _, l := range loads {
+		variance += (l - s.Mean) * (l - s.Mean)
+	}
+
+	s.StdDev = math.Sqrt(variance / float64(len(loads)))
+
+	if s.Mean > 0 {
+		s.CV = s.StdDev / s.Mean
+	}
+
+	return s
+}
+
+// Skew returns the relative deviation of the load of each shard from
+// the mean load, e.g. 0.25 means the shard got 25% more than the mean,
+// and -0.25 means the shard got 25% less than the mean.
+func Skew(loads map[string]float64) map[string]float64 {
+	skew := make(map[string]float64, len(loads))
+	if len(loads) == 0 {
+		return skew
+	}
+
+	var sum float64
+
+	for _, l := range loads {
+		sum += l
+	}
+
+	mean := sum / float64(len(loads))
+
+	for name, l := range loads {
+		if mean > 0 {
+			skew[name] = (l - mean) / mean
+		} else {
+			skew[name] = 0
+		}
+	}
+
+	return skew
+}
+
+// Item is a unit of load, e.g. a single YAML item at the split point.
+type Item struct {
+	// Key is the consistent hashing key of the item.
+	Key []byte
+	// Size is the size of the item in bytes.
+	Size int
+}
+
+// Result represents the result of a simulation.
+type Result struct {
+	Items Stats
+	Bytes Stats
+	// Moved is the share of item replicas, which are placed
+	// on different shards comparing to the current layout.
+	Moved float64
+}
+
+// Distribute computes the balance of items placed on shards with names nodeNames,
+// where owners[i] and current[i] are the shards that get items[i]
+// in the simulated and in the current layout respectively.
+// The owners come from the real placement of the items, e.g. by the routes,
+// the directives or the per-item replication factors, not only by consistent hashing.
+func Distribute(items []Item, nodeNames []string, owners, current [][]string) Result {
+	var (
+		itemsCount = make(map[string]float64, len(nodeNames))
+		bytesCount = make(map[string]float64, len(nodeNames))
+		replicas   int
+		moved      int
+	)
+
+	for i, item := range items {
+		currentOwners := make(map[string]struct{})
+		for _, name := range current[i] {
+			currentOwners[name] = struct{}{}
+		}
+
+		for _, name := range owners[i] {
+			itemsCount[name]++
+			bytesCount[name] += float64(item.Size)
+			replicas++
+
+			if _, ok := currentOwners[name]; !ok {
+				moved++
+			}
+		}
+	}
+
+	itemLoads := make([]float64, len(nodeNames))
+	byteLoads := make([]float64, len(nodeNames))
+
+	for i, name := range nodeNames {
+		itemLoads[i] = itemsCount[name]
+		byteLoads[i] = bytesCount[name]
+	}
+
+	res := Result{
+		Items: Compute(itemLoads),
+		Bytes: Compute(byteLoads),
+	}
+
+	if replicas > 0 {
+		res.Moved = float64(moved) / float64(replicas)
+	}
+
+	return res
+}
@@ -0,0 +1,80 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompute(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, Stats{}, Compute(nil))
+
+	s := Compute([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+	require.Equal(t, 2.0, s.Min)
+	require.Equal(t, 9.0, s.Max)
+	require.Equal(t, 5.0, s.Mean)
+	require.Equal(t, 2.0, s.StdDev)
+	require.Equal(t, 0.4, s.CV)
+
+	s = Compute([]float64{0, 0})
+	require.Equal(t, 0.0, s.CV)
+}
+
+func TestSkew(t *testing.T) {
+	t.Parallel()
+
+	require.Empty(t, Skew(nil))
+
+	skew := Skew(map[string]float64{"alpha": 50, "beta": 100, "gamma": 150})
+	require.Equal(t, map[string]float64{"alpha": -0.5, "beta": 0, "gamma": 0.5}, skew)
+
+	skew = Skew(map[string]float64{"alpha": 0, "beta": 0})
+	require.Equal(t, map[string]float64{"alpha": 0, "beta": 0}, skew)
+}
+
+func TestDistribute(t *testing.T) {
+	t.Parallel()
+
+	nodeNames := []string{"alpha", "beta"}
+	items := []Item{
+		{Key: []byte("a"), Size: 10},
+		{Key: []byte("a"), Size: 30},
+	}
+
+	// The items with the same key may be placed differently.
+	current := [][]string{{"alpha"}, {"beta"}}
+
+	res := Distribute(items, nodeNames, current, current)
+	require.Equal(t, 0.0, res.Moved)
+	require.Equal(t, 0.0, res.Items.StdDev)
+	require.Equal(t, 10.0, res.Bytes.Min)
+	require.Equal(t, 30.0, res.Bytes.Max)
+
+	res = Distribute(items, nodeNames, [][]string{{"alpha"}, {"alpha", "beta"}}, current)
+	require.Equal(t, 1.0/3, res.Moved)
+	require.Equal(t, 2.0, res.Items.Max)
+	require.Equal(t, 40.0, res.Bytes.Max)
+
+	// Everything goes to alpha: one of two replicas moves.
+	res = Distribute(items, nodeNames, [][]string{{"alpha"}, {"alpha"}}, current)
+	require.Equal(t, 0.5, res.Moved)
+	require.Equal(t, 0.0, res.Items.Min)
+	require.Equal(t, 2.0, res.Items.Max)
+	require.Equal(t, 1.0, res.Items.CV)
+}
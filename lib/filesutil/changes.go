@@ -0,0 +1,183 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Changes lists the files of the destination directory
+// by the kind of change, the files are relative to the directory.
+type Changes struct {
+	Added     []string
+	Changed   []string
+	Unchanged []string
+	Removed   []string
+}
+
+// Any reports whether any file is added, changed or removed.
+func (c *Changes) Any() bool {
+	return len(c.Added)+len(c.Changed)+len(c.Removed) > 0
+}
+
+// String returns the summary of the changes.
+func (c *Changes) String() string {
+	return fmt.Sprintf("%d added, %d changed, %d unchanged, %d removed",
+		len(c.Added), len(c.Changed), len(c.Unchanged), len(c.Removed))
+}
+
+// Compare compares the given files of srcDir with the same files of dstDir
+// by the content hash and fills out Added, Changed and Unchanged.
+// files are relative to srcDir, e.g. the result of ListTree(srcDir).
+func Compare(srcDir, dstDir string, files []string) (*Changes, error) {
+	c := &Changes{}
+
+	for _, file := range files {
+		srcPath := filepath.Join(srcDir, filepath.FromSlash(file))
+		dstPath := filepath.Join(dstDir, filepath.FromSlash(file))
+
+		equal, err := sameContent(srcPath, dstPath)
+
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			c.Added = append(c.Added, file)
+		case err != nil:
+			return nil, err
+		case equal:
+			c.Unchanged = append(c.Unchanged, file)
+		default:
+			c.Changed = append(c.Changed, file)
+		}
+	}
+
+	return c, nil
+}
+
+// UpdateDirAll copies the files of srcDir into dstDir along with their
+// owners and modes, leaving the files with the same content untouched,
+// so their mtime does not change.
+// This fills out Added, Changed and Unchanged of the returned Changes.
+func UpdateDirAll(srcDir, dstDir string) (*Changes, error) {
+	files, err := ListTree(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Compare(srcDir, dstDir, files)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, list := range [][]string{c.Added, c.Changed} {
+		for _, file := range list {
+			srcPath := filepath.Join(srcDir, filepath.FromSlash(file))
+			dstPath := filepath.Join(dstDir, filepath.FromSlash(file))
+
+			if err := createIfNotExists(filepath.Dir(dstPath), 0o755); err != nil {
+				return nil, err
+			}
+
+			if err := copyFile(srcPath, dstPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// sameContent reports whether the files have the same content.
+// The error wraps os.ErrNotExist if dstFile does not exist.
+func sameContent(srcFile, dstFile string) (bool, error) {
+	dstInfo, err := os.Stat(dstFile)
+	if err != nil {
+		return false, err
+	}
+
+	srcInfo, err := os.Stat(srcFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to get file info %q: %w", srcFile, err)
+	}
+
+	if srcInfo.Size() != dstInfo.Size() {
+		return false, nil
+	}
+
+	srcSum, err := fileHash(srcFile)
+	if err != nil {
+		return false, err
+	}
+
+	dstSum, err := fileHash(dstFile)
+	if err != nil {
+		return false, err
+	}
+
+	return srcSum == dstSum, nil
+}
+
+func fileHash(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %q: %w", path, err)
+	}
+	defer f.Close()
+
+	h := xxhash.New()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, fmt.Errorf("failed to read %q: %w", path, err)
+	}
+
+	return h.Sum64(), nil
+}
+
+// CompareDirAll compares all files of srcDir with the files of dstDir
+// by the content hash. The files of dstDir that are not in srcDir are Removed.
+func CompareDirAll(srcDir, dstDir string) (*Changes, error) {
+	srcFiles, err := ListTree(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Compare(srcDir, dstDir, srcFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	dstFiles, err := ListTree(dstDir)
+	if err != nil {
+		return nil, err
+	}
+
+	srcSet := make(map[string]struct{}, len(srcFiles))
+	for _, file := range srcFiles {
+		srcSet[file] = struct{}{}
+	}
+
+	for _, file := range dstFiles {
+		if _, ok := srcSet[file]; !ok {
+			c.Removed = append(c.Removed, file)
+		}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestUpdateDirAll(t *testing.T) {
+	t.Parallel()
+
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+
+	writeFiles(t, srcDir, map[string]string{
+		"alpha/same.yml":    "a: 1\n",
+		"alpha/changed.yml": "b: 2\n",
+		"alpha/resized.yml": "c: 33\n",
+		"beta/added.yml":    "d: 4\n",
+	})
+	writeFiles(t, dstDir, map[string]string{
+		"alpha/same.yml":    "a: 1\n",
+		"alpha/changed.yml": "b: 1\n",
+		"alpha/resized.yml": "c: 3\n",
+		"alpha/other.yml":   "e: 5\n",
+	})
+
+	// The modes of the source files are kept for the added and the changed files.
+	require.NoError(t, os.Chmod(filepath.Join(srcDir, "alpha/changed.yml"), 0o600))
+	require.NoError(t, os.Chmod(filepath.Join(srcDir, "beta/added.yml"), 0o640))
+
+	past := time.Now().Add(-time.Hour)
+	samePath := filepath.Join(dstDir, "alpha/same.yml")
+	require.NoError(t, os.Chtimes(samePath, past, past))
+
+	c, err := UpdateDirAll(srcDir, dstDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"beta/added.yml"}, c.Added)
+	require.Equal(t, []string{"alpha/changed.yml", "alpha/resized.yml"}, c.Changed)
+	require.Equal(t, []string{"alpha/same.yml"}, c.Unchanged)
+	require.Empty(t, c.Removed)
+	require.True(t, c.Any())
+	require.Equal(t, "1 added, 2 changed, 1 unchanged, 0 removed", c.String())
+
+	// The unchanged file is not rewritten.
+	info, err := os.Stat(samePath)
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(past))
+
+	for _, file := range []string{"alpha/changed.yml", "alpha/resized.yml", "beta/added.yml"} {
+		b, err := os.ReadFile(filepath.Join(dstDir, file))
+		require.NoError(t, err)
+		expected, err := os.ReadFile(filepath.Join(srcDir, file))
+		require.NoError(t, err)
+		require.Equal(t, expected, b)
+	}
+
+	for file, mode := range map[string]os.FileMode{
+		"alpha/changed.yml": 0o600,
+		"alpha/resized.yml": 0o644,
+		"beta/added.yml":    0o640,
+	} {
+		info, err := os.Stat(filepath.Join(dstDir, file))
+		require.NoError(t, err)
+		require.Equal(t, mode, info.Mode().Perm(), file)
+	}
+
+	// Nothing changes the second time.
+	c, err = UpdateDirAll(srcDir, dstDir)
+	require.NoError(t, err)
+	require.False(t, c.Any())
+	require.Len(t, c.Unchanged, 4)
+}
+
+func TestCompareDirAll(t *testing.T) {
+	t.Parallel()
+
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+
+	writeFiles(t, srcDir, map[string]string{
+		"alpha/same.yml":    "a: 1\n",
+		"alpha/changed.yml": "b: 2\n",
+		"beta/added.yml":    "d: 4\n",
+	})
+	writeFiles(t, dstDir, map[string]string{
+		"alpha/same.yml":    "a: 1\n",
+		"alpha/changed.yml": "b: 1\n",
+		"gamma/removed.yml": "e: 5\n",
+	})
+
+	c, err := CompareDirAll(srcDir, dstDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"beta/added.yml"}, c.Added)
+	require.Equal(t, []string{"alpha/changed.yml"}, c.Changed)
+	require.Equal(t, []string{"alpha/same.yml"}, c.Unchanged)
+	require.Equal(t, []string{"gamma/removed.yml"}, c.Removed)
+
+	// dstDir is not modified.
+	b, err := os.ReadFile(filepath.Join(dstDir, "alpha/changed.yml"))
+	require.NoError(t, err)
+	require.Equal(t, "b: 1\n", string(b))
+}
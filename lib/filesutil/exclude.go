@@ -0,0 +1,226 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// IgnoreFileName is the name of the file listing
+// the patterns of the files to exclude, one per line.
+const IgnoreFileName = ".ypignore"
+
+// Excluder reports whether a file is excluded by the given patterns.
+//
+// A pattern containing "/" is matched against the file path,
+// a relative pattern is relative to the current directory
+// or to the directory of the ignore file it is read from.
+// A pattern without "/" is matched against the base name of the file,
+// or, if it is read from an ignore file, against every element
+// of the file path relative to the ignore file directory,
+// so "vendor" excludes all files under any "vendor" directory.
+// A pattern ending with "/" matches everything under the directory.
+type Excluder struct {
+	patterns []excludePattern
+}
+
+type excludePattern struct {
+	// base is the ignore file directory, it is empty for --exclude patterns.
+	base    string
+	pattern string
+	// anyElem is true for the ignore file patterns without "/".
+	anyElem bool
+}
+
+// NewExcluder creates a new Excluder with the given patterns.
+func NewExcluder(patterns ...string) (*Excluder, error) {
+	e := &Excluder{}
+
+	for _, pattern := range patterns {
+		if err := e.add("", pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// AddIgnoreFile adds the patterns from the ignore file in dir.
+// Empty lines and lines starting with "#" are skipped.
+// There is no error if the ignore file does not exist.
+func (e *Excluder) AddIgnoreFile(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for %q: %w", dir, err)
+	}
+
+	ignoreFile := filepath.Join(dir, IgnoreFileName)
+
+	f, err := os.Open(ignoreFile)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to open %q: %w", ignoreFile, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := e.add(dir, line); err != nil {
+			return fmt.Errorf("invalid pattern in %q: %w", ignoreFile, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %q: %w", ignoreFile, err)
+	}
+
+	return nil
+}
+
+func (e *Excluder) add(base, pattern string) error {
+	pattern = filepath.ToSlash(pattern)
+
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	if !doublestar.ValidatePattern(pattern) {
+		return fmt.Errorf("invalid exclude pattern %q", pattern)
+	}
+
+	p := excludePattern{pattern: pattern}
+
+	switch {
+	case !strings.Contains(pattern, "/"):
+		p.base = base
+		p.anyElem = len(base) > 0
+	case len(base) > 0:
+		p.pattern = filepath.ToSlash(filepath.Join(base, pattern))
+	default:
+		absPattern, err := filepath.Abs(pattern)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for %q: %w", pattern, err)
+		}
+
+		p.pattern = filepath.ToSlash(absPattern)
+	}
+
+	e.patterns = append(e.patterns, p)
+
+	return nil
+}
+
+// Excluded reports whether the file at the absolute path is excluded.
+func (e *Excluder) Excluded(path string) bool {
+	path = filepath.ToSlash(path)
+
+	for _, p := range e.patterns {
+		if p.matches(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *excludePattern) matches(path string) bool {
+	if strings.Contains(p.pattern, "/") {
+		ok, _ := doublestar.Match(p.pattern, path)
+		return ok
+	}
+
+	if !p.anyElem {
+		ok, _ := doublestar.Match(p.pattern, filepath.Base(path))
+		return ok
+	}
+
+	rel, ok := strings.CutPrefix(path, filepath.ToSlash(p.base)+"/")
+	if !ok {
+		return false
+	}
+
+	for _, elem := range strings.Split(rel, "/") {
+		if ok, _ := doublestar.Match(p.pattern, elem); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PatternBase returns the directory part of the pattern
+// that has no glob meta characters, e.g. "rules" for "rules/**/*.yml".
+func PatternBase(pattern string) string {
+	base, _ := doublestar.SplitPattern(filepath.ToSlash(pattern))
+	return filepath.FromSlash(base)
+}
+
+// ListAll returns the sorted list of the absolute paths of all files
+// matching any of the patterns and not excluded by e.
+// A file matching multiple patterns is listed once.
+// Directories and other non-regular files are skipped,
+// so "dir/**" lists all files under dir.
+func ListAll(patterns []string, e *Excluder) ([]string, error) {
+	seen := make(map[string]struct{})
+
+	var files []string
+
+	for _, pattern := range patterns {
+		matches, err := List(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range matches {
+			if _, ok := seen[file]; ok || e.Excluded(file) {
+				continue
+			}
+
+			seen[file] = struct{}{}
+
+			fileInfo, err := os.Stat(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to stat %q: %w", file, err)
+			}
+
+			if !fileInfo.Mode().IsRegular() {
+				continue
+			}
+
+			files = append(files, file)
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesutil
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExcluder(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		IgnoreFileName: "# fixtures\n\nvendor\nlegacy/\n",
+	})
+
+	e, err := NewExcluder("*_test.yaml", filepath.Join(dir, "rules/tmp/**"))
+	require.NoError(t, err)
+	require.NoError(t, e.AddIgnoreFile(dir))
+	// The missing ignore file is fine.
+	require.NoError(t, e.AddIgnoreFile(filepath.Join(dir, "missing")))
+
+	f := func(path string, expected bool) {
+		t.Helper()
+		require.Equal(t, expected, e.Excluded(filepath.Join(dir, path)), path)
+	}
+
+	f("rules/kube.yaml", false)
+	f("rules/kube_test.yaml", true)
+	f("rules/tmp/kube.yaml", true)
+	f("rules/vendor/kube.yaml", true)
+	f("vendor/rules/kube.yaml", true)
+	f("vendors/kube.yaml", false)
+	f("legacy/kube.yaml", true)
+	f("rules/legacy/kube.yaml", false)
+
+	// The ignore file patterns without "/" are scoped to its directory.
+	require.False(t, e.Excluded("/elsewhere/vendor/kube.yaml"))
+
+	_, err = NewExcluder("[")
+	require.ErrorContains(t, err, "invalid exclude pattern")
+}
+
+func TestListAll(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"a/rules.yml":      "a: 1\n",
+		"a/rules_test.yml": "a: 1\n",
+		"b/rules.yaml":     "b: 1\n",
+	})
+
+	e, err := NewExcluder("*_test.yml")
+	require.NoError(t, err)
+
+	files, err := ListAll([]string{
+		filepath.Join(dir, "**/*.{yml,yaml}"),
+		filepath.Join(dir, "a/*.yml"),
+	}, e)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "a/rules.yml"),
+		filepath.Join(dir, "b/rules.yaml"),
+	}, files)
+
+	// Directories are skipped.
+	files, err = ListAll([]string{filepath.Join(dir, "b/**")}, e)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "b/rules.yaml")}, files)
+
+	require.Equal(t, filepath.Join(dir, "a"), PatternBase(filepath.Join(dir, "a/**/*.yml")))
+	require.Equal(t, ".", PatternBase("*.yml"))
+}
+
+func TestCollisions(t *testing.T) {
+	t.Parallel()
+
+	f := func(files, roots []string, foldCase bool, expected []Collision) {
+		t.Helper()
+
+		require.Equal(t, expected, Collisions(files, roots, foldCase))
+	}
+
+	// The same relative path in different roots.
+	f([]string{"/src/a/rules.yml", "/src/b/rules.yml", "/src/b/other.yml"}, []string{"/src/a", "/src/b/"}, false,
+		[]Collision{{First: "/src/a/rules.yml", Second: "/src/b/rules.yml", Path: "rules.yml"}})
+	// The nested roots do not collide.
+	f([]string{"/src/rules.yml", "/src/team-a/rules.yml"}, []string{"/src/team-a", "/src"}, false, nil)
+	// The paths differing in case only.
+	f([]string{"/src/a/Rules.yml", "/src/b/rules.yml"}, []string{"/src/a", "/src/b"}, false, nil)
+	f([]string{"/src/a/Rules.yml", "/src/b/rules.yml"}, []string{"/src/a", "/src/b"}, true,
+		[]Collision{{First: "/src/a/Rules.yml", Second: "/src/b/rules.yml", Path: "rules.yml"}})
+	// The files out of the roots are skipped.
+	f([]string{"/src/a/rules.yml", "/other/rules.yml"}, []string{"/src/a"}, false, nil)
+}
@@ -127,6 +127,34 @@ func CopyContent(srcFile, dstFile string) error {
 	return nil
 }
 
+// copyFile copies the regular srcFile to dstFile
+// along with its owner and mode, as CopyDirAll does.
+func copyFile(srcFile, dstFile string) error {
+	fileInfo, err := os.Stat(srcFile)
+	if err != nil {
+		return fmt.Errorf("failed to get file info %q: %w", srcFile, err)
+	}
+
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("failed to get raw syscall.Stat_t data for %q", srcFile)
+	}
+
+	if err := CopyContent(srcFile, dstFile); err != nil {
+		return err
+	}
+
+	if err := os.Lchown(dstFile, int(stat.Uid), int(stat.Gid)); err != nil {
+		return fmt.Errorf("failed to change owner %q: %w", dstFile, err)
+	}
+
+	if err := os.Chmod(dstFile, fileInfo.Mode()); err != nil {
+		return fmt.Errorf("failed to change mode %q: %w", dstFile, err)
+	}
+
+	return nil
+}
+
 func exists(filePath string) bool {
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return false
@@ -171,6 +199,62 @@ func LongestCommonPath(paths []string) string {
 	return commonPrefix[:idx+1]
 }
 
+// Collision is a pair of files that have the same path
+// relative to their roots, see Collisions.
+type Collision struct {
+	First  string
+	Second string
+	// Path is the path of both files relative to their roots.
+	Path string
+}
+
+// Collisions returns the pairs of files that have the same path relative
+// to their roots, e.g. "a/rules.yml" and "b/rules.yml" for the roots "a" and "b",
+// so they would be written to the same file if each root was the output root.
+// The root of a file is the outermost root containing it, so the nested roots,
+// e.g. "rules" and "rules/team-a", do not collide. The files out of all
+// roots are skipped. If foldCase is true, the paths differing in case
+// only collide as well, as on the case-insensitive file systems.
+func Collisions(files, roots []string, foldCase bool) []Collision {
+	dirs := make([]string, 0, len(roots))
+
+	for _, root := range roots {
+		dirs = append(dirs, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+	}
+
+	// The outermost roots go first.
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) < len(dirs[j]) })
+
+	var (
+		seen       = make(map[string]string, len(files))
+		collisions []Collision
+	)
+
+	for _, file := range files {
+		for _, dir := range dirs {
+			rel, ok := strings.CutPrefix(file, dir)
+			if !ok {
+				continue
+			}
+
+			key := rel
+			if foldCase {
+				key = strings.ToLower(key)
+			}
+
+			if other, ok := seen[key]; ok && other != file {
+				collisions = append(collisions, Collision{First: other, Second: file, Path: rel})
+			} else {
+				seen[key] = file
+			}
+
+			break
+		}
+	}
+
+	return collisions
+}
+
 func longestCommonPrefix(strs []string) string {
 	switch {
 	case len(strs) == 0:
@@ -223,3 +307,35 @@ func List(pattern string) ([]string, error) {
 
 	return matches, nil
 }
+
+// ListTree returns the sorted list of all regular files
+// under dir relative to dir, e.g. "alpha/rules/kube.yml".
+func ListTree(dir string) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in %q: %w", dir, err)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
@@ -0,0 +1,260 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package generations implements atomic publishing of a directory.
+//
+// Every published directory is stored as a new generation
+// and the current generation is switched with a symlink:
+//
+//	<root>/generations/<id>/...
+//	<root>/current -> generations/<id>
+//
+// Readers use <root>/current/..., so they never see
+// a half-written or a mixed generation.
+package generations
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asokolov365/YamlPartitioner/lib/filesutil"
+)
+
+const (
+	// CurrentLink is the name of the symlink to the current generation.
+	CurrentLink = "current"
+	// Dir is the name of the directory where generations are stored.
+	Dir = "generations"
+)
+
+// idLayout makes the generation ids sortable in the order of creation.
+const idLayout = "20060102T150405.000000000Z"
+
+// Store represents generations of a directory stored under root.
+type Store struct {
+	root string
+	keep int
+}
+
+// New creates a new Store under root that keeps the last keep generations.
+func New(root string, keep int) (*Store, error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("number of generations to keep must be >= 1")
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for %q: %w", root, err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, Dir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to make directory %q: %w", filepath.Join(root, Dir), err)
+	}
+
+	return &Store{root: root, keep: keep}, nil
+}
+
+// Publish copies the content of srcDir into a new generation,
+// fsyncs it and switches the current generation to it.
+// Then the generations older than the last kept ones are removed.
+// Publish returns the id of the new generation.
+func (s *Store) Publish(srcDir string) (string, error) {
+	id := time.Now().UTC().Format(idLayout)
+	genDir := s.Path(id)
+	// The generation is copied under a hidden name first,
+	// so a crash while copying leaves no partial generation behind.
+	tmpDir := filepath.Join(s.root, Dir, "."+id+".tmp")
+
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to make directory %q: %w", tmpDir, err)
+	}
+
+	if err := filesutil.CopyDirAll(srcDir, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+
+	if err := syncAll(tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+
+	if err := os.Rename(tmpDir, genDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("failed to rename %q: %w", tmpDir, err)
+	}
+
+	if err := fsync(filepath.Join(s.root, Dir)); err != nil {
+		return "", err
+	}
+
+	if err := s.switchTo(id); err != nil {
+		return "", err
+	}
+
+	return id, s.prune()
+}
+
+// List returns the ids of the stored generations, oldest first.
+func (s *Store) List() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, Dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %q: %w", filepath.Join(s.root, Dir), err)
+	}
+
+	ids := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		// Skipping the generations being copied
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		ids = append(ids, entry.Name())
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// Current returns the id of the current generation
+// or an empty string if nothing is published yet.
+func (s *Store) Current() (string, error) {
+	target, err := os.Readlink(filepath.Join(s.root, CurrentLink))
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("failed to read symlink %q: %w", filepath.Join(s.root, CurrentLink), err)
+	}
+
+	return filepath.Base(target), nil
+}
+
+// Path returns the path to the generation with the given id.
+func (s *Store) Path(id string) string {
+	return filepath.Join(s.root, Dir, id)
+}
+
+// Rollback switches the current generation to the one with the given id.
+// If id is empty, this is the generation preceding the current one.
+// Rollback returns the id of the new current generation.
+func (s *Store) Rollback(id string) (string, error) {
+	ids, err := s.List()
+	if err != nil {
+		return "", err
+	}
+
+	current, err := s.Current()
+	if err != nil {
+		return "", err
+	}
+
+	if len(id) == 0 {
+		idx := sort.SearchStrings(ids, current)
+		if idx == 0 || len(current) == 0 {
+			return "", fmt.Errorf("no generation found before the current one %q", current)
+		}
+
+		id = ids[idx-1]
+	}
+
+	if idx := sort.SearchStrings(ids, id); idx == len(ids) || ids[idx] != id {
+		return "", fmt.Errorf("generation %q not found", id)
+	}
+
+	if err := s.switchTo(id); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// switchTo atomically replaces the current symlink
+// with the one pointing to the generation id.
+func (s *Store) switchTo(id string) error {
+	link := filepath.Join(s.root, CurrentLink)
+	tmpLink := filepath.Join(s.root, "."+CurrentLink+".tmp")
+
+	if err := os.Remove(tmpLink); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %q: %w", tmpLink, err)
+	}
+
+	// The relative target keeps the root relocatable.
+	if err := os.Symlink(filepath.Join(Dir, id), tmpLink); err != nil {
+		return fmt.Errorf("failed to create symlink %q: %w", tmpLink, err)
+	}
+
+	if err := os.Rename(tmpLink, link); err != nil {
+		os.Remove(tmpLink)
+		return fmt.Errorf("failed to switch %q to %q: %w", link, id, err)
+	}
+
+	return fsync(s.root)
+}
+
+// prune removes the generations older than the last kept ones.
+// This is called right after switching to the newest generation,
+// so the current generation is always kept.
+func (s *Store) prune() error {
+	ids, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(ids)-s.keep; i++ {
+		if err := os.RemoveAll(filepath.Join(s.root, Dir, ids[i])); err != nil {
+			return fmt.Errorf("failed to remove generation %q: %w", ids[i], err)
+		}
+	}
+
+	return nil
+}
+
+// syncAll fsyncs all regular files and directories under dir.
+func syncAll(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		return fsync(path)
+	})
+}
+
+// fsync fsyncs the file or the directory at path.
+func fsync(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync %q: %w", path, err)
+	}
+
+	return nil
+}
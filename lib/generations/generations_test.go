@@ -0,0 +1,137 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// publish publishes a generation with a single file of the given content.
+func publish(t *testing.T, s *Store, content string) string {
+	t.Helper()
+
+	srcDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "alpha"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "alpha", "rules.yml"), []byte(content), 0o644))
+
+	id, err := s.Publish(srcDir)
+	require.NoError(t, err)
+
+	return id
+}
+
+func readCurrent(t *testing.T, root string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join(root, CurrentLink, "alpha", "rules.yml"))
+	require.NoError(t, err)
+
+	return string(b)
+}
+
+func TestPublish(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	s, err := New(root, 2)
+	require.NoError(t, err)
+
+	current, err := s.Current()
+	require.NoError(t, err)
+	require.Empty(t, current)
+
+	id1 := publish(t, s, "gen1")
+	require.Equal(t, "gen1", readCurrent(t, root))
+
+	id2 := publish(t, s, "gen2")
+	id3 := publish(t, s, "gen3")
+	require.Equal(t, "gen3", readCurrent(t, root))
+
+	// Only the last 2 generations are kept.
+	ids, err := s.List()
+	require.NoError(t, err)
+	require.Equal(t, []string{id2, id3}, ids)
+	require.NoDirExists(t, filepath.Join(root, Dir, id1))
+
+	current, err = s.Current()
+	require.NoError(t, err)
+	require.Equal(t, id3, current)
+}
+
+func TestRollback(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	s, err := New(root, 2)
+	require.NoError(t, err)
+
+	_, err = s.Rollback("")
+	require.ErrorContains(t, err, "no generation found before the current one")
+
+	id1 := publish(t, s, "gen1")
+	id2 := publish(t, s, "gen2")
+
+	id, err := s.Rollback("")
+	require.NoError(t, err)
+	require.Equal(t, id1, id)
+	require.Equal(t, "gen1", readCurrent(t, root))
+
+	_, err = s.Rollback("")
+	require.ErrorContains(t, err, "no generation found before the current one")
+
+	id, err = s.Rollback(id2)
+	require.NoError(t, err)
+	require.Equal(t, id2, id)
+	require.Equal(t, "gen2", readCurrent(t, root))
+
+	_, err = s.Rollback("unknown")
+	require.ErrorContains(t, err, `generation "unknown" not found`)
+}
+
+func TestPublish_AfterRollback(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	s, err := New(root, 2)
+	require.NoError(t, err)
+
+	id1 := publish(t, s, "gen1")
+	id2 := publish(t, s, "gen2")
+
+	_, err = s.Rollback(id1)
+	require.NoError(t, err)
+
+	// The new generation becomes current regardless of the rollback.
+	id3 := publish(t, s, "gen3")
+	require.Equal(t, "gen3", readCurrent(t, root))
+
+	ids, err := s.List()
+	require.NoError(t, err)
+	require.Equal(t, []string{id2, id3}, ids)
+}
+
+func TestNew_InvalidKeep(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(t.TempDir(), 0)
+	require.ErrorContains(t, err, "must be >= 1")
+}
@@ -18,6 +18,7 @@ package hrw
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/asokolov365/YamlPartitioner/lib/bytesutil"
@@ -44,6 +45,28 @@ type Rendezvous struct {
 //	Sum64 computes the 64-bit xxHash digest of input.
 type Hasher func(input []byte) uint64
 
+// Seeded returns the hasher with the seed mixed into every input,
+// so the key and node hashes and thus the placement of keys change with the seed.
+// The same seed always gives the same placement. The hasher is
+// returned as is for the empty seed, so the placement does not change.
+// It seeds the jump.Hasher as well, e.g. jump.Hasher(Seeded(xxhash.Sum64, seed)).
+func Seeded(hasher Hasher, seed string) Hasher {
+	if len(seed) == 0 {
+		return hasher
+	}
+
+	return func(input []byte) uint64 {
+		b := make([]byte, 0, len(seed)+1+len(input))
+		b = append(b, seed...)
+		// The separator keeps the seed and the input apart,
+		// e.g. "ab"+"c" and "a"+"bc" are hashed differently.
+		b = append(b, 0)
+		b = append(b, input...)
+
+		return hasher(b)
+	}
+}
+
 // New creates a new Rendezvous that implements Rendezvous
 // or highest random weight (HRW) hashing algorithm.
 func New(hasher Hasher, nodes ...string) (*Rendezvous, error) {
@@ -135,6 +158,36 @@ func (r *Rendezvous) GetN(key []byte, replicasCount int) map[string]struct{} {
 	return res
 }
 
+// Lookup gets N most suitable node names for a key
+// in the order of preference, i.e. the same nodes as GetN does,
+// but the first one is the primary owner of the key.
+func (r *Rendezvous) Lookup(key []byte, replicasCount int) []string {
+	if len(r.nodes) == 0 {
+		return []string{}
+	}
+
+	nodeIndecies := r.getNBestNodesForKey(key, replicasCount)
+	res := make([]string, len(nodeIndecies))
+
+	for i, idx := range nodeIndecies {
+		res[i] = r.nodeNames[idx]
+	}
+
+	return res
+}
+
+// Score returns the rendezvous score (weight) of the node for a key.
+// The node with the highest score is the primary owner of the key.
+// It returns false if the node is not in the Rendezvous.
+func (r *Rendezvous) Score(key []byte, node string) (uint64, bool) {
+	idx, ok := r.nodes[node]
+	if !ok {
+		return 0, false
+	}
+
+	return xorshiftMult64(r.hasher(key) ^ r.nodeHashes[idx]), true
+}
+
 func (r *Rendezvous) getNBestNodesForKey(key []byte, replicasCount int) []int {
 	var nodeIndecies []int
 
@@ -142,12 +195,23 @@ func (r *Rendezvous) getNBestNodesForKey(key []byte, replicasCount int) []int {
 		return []int{0}
 	}
 
+	keyHash := r.hasher(key)
+
+	// All nodes get the key, they are ordered by score,
+	// so the first one is the primary owner as for fewer replicas.
 	if replicasCount >= len(r.nodes) {
+		scores := make([]uint64, len(r.nodes))
 		nodeIndecies = make([]int, len(r.nodes))
-		for i := 0; i < len(r.nodes); i++ {
+
+		for i, nodeHash := range r.nodeHashes {
+			scores[i] = xorshiftMult64(keyHash ^ nodeHash)
 			nodeIndecies[i] = i
 		}
 
+		sort.SliceStable(nodeIndecies, func(i, j int) bool {
+			return scores[nodeIndecies[i]] > scores[nodeIndecies[j]]
+		})
+
 		return nodeIndecies
 	}
 
@@ -155,8 +219,6 @@ func (r *Rendezvous) getNBestNodesForKey(key []byte, replicasCount int) []int {
 		replicasCount = 1
 	}
 
-	keyHash := r.hasher(key)
-
 	var maxIdx int
 
 	maxHash := xorshiftMult64(keyHash ^ r.nodeHashes[0]) // first node
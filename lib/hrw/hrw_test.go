@@ -428,3 +428,111 @@ func TestMovers(t *testing.T) {
 	require.Equal(t, 0, unnecessaryMovers)
 	require.Less(t, totalMovers, moversThreshold)
 }
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	nodeNum := 8
+	nodes := make([]string, nodeNum)
+
+	for i := 0; i < nodeNum; i++ {
+		nodes[i] = fmt.Sprintf("node%d", i)
+	}
+
+	r, err := New(xxhash.Sum64, nodes...)
+	require.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		key := randStringAsBytes()
+
+		owners := r.Lookup(key, 3)
+		require.Equal(t, 3, len(owners))
+		require.Equal(t, r.Get(key), owners[0])
+
+		retNodes := r.GetN(key, 3)
+		for _, n := range owners {
+			require.Contains(t, retNodes, n)
+		}
+
+		// The primary owner must have the highest score.
+		maxScore, ok := r.Score(key, owners[0])
+		require.True(t, ok)
+
+		for _, n := range nodes {
+			score, ok := r.Score(key, n)
+			require.True(t, ok)
+			require.LessOrEqual(t, score, maxScore)
+		}
+	}
+
+	// All nodes are ordered by score when every node gets the key.
+	for i := 0; i < 1000; i++ {
+		key := randStringAsBytes()
+
+		owners := r.Lookup(key, nodeNum)
+		require.Equal(t, nodeNum, len(owners))
+		require.Equal(t, r.Get(key), owners[0])
+
+		for j := 1; j < len(owners); j++ {
+			prev, _ := r.Score(key, owners[j-1])
+			score, _ := r.Score(key, owners[j])
+			require.Greater(t, prev, score)
+		}
+	}
+
+	_, ok := r.Score([]byte("hello"), "unknown")
+	require.False(t, ok)
+	require.Empty(t, (&Rendezvous{}).Lookup([]byte("hello"), 1))
+}
+
+func TestSeeded(t *testing.T) {
+	t.Parallel()
+
+	nodeNum := 5
+	nodes := make([]string, nodeNum)
+
+	for i := 0; i < nodeNum; i++ {
+		nodes[i] = fmt.Sprintf("node%d", i)
+	}
+
+	r, err := New(xxhash.Sum64, nodes...)
+	require.NoError(t, err)
+
+	rEmpty, err := New(Seeded(xxhash.Sum64, ""), nodes...)
+	require.NoError(t, err)
+
+	rSeed1, err := New(Seeded(xxhash.Sum64, "2024-06"), nodes...)
+	require.NoError(t, err)
+
+	rSeed2, err := New(Seeded(xxhash.Sum64, "2024-06"), nodes...)
+	require.NoError(t, err)
+
+	numKeys := 10000
+	moved := 0
+	buckets := make(map[string]int, nodeNum)
+
+	for i := 0; i < numKeys; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+
+		// The empty seed keeps the placement.
+		require.Equal(t, r.Get(key), rEmpty.Get(key))
+		// The same seed gives the same placement.
+		require.Equal(t, rSeed1.Lookup(key, 2), rSeed2.Lookup(key, 2))
+
+		n := rSeed1.Get(key)
+		buckets[n]++
+
+		if n != r.Get(key) {
+			moved++
+		}
+	}
+
+	// About 4/5 of keys must move to another node with the seed.
+	require.Less(t, numKeys*70/100, moved)
+	require.Less(t, moved, numKeys*90/100)
+
+	for n, l := range buckets {
+		require.Less(t, numKeys*15/100, l, n)
+		require.Less(t, l, numKeys*25/100, n)
+	}
+}
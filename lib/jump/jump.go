@@ -0,0 +1,137 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jump implements the Jump consistent hashing algorithm.
+package jump
+
+import (
+	"fmt"
+)
+
+// Jump implements the Jump consistent hashing algorithm described in
+// "A Fast, Minimal Memory, Consistent Hash Algorithm"
+// by John Lamping and Eric Veach, https://arxiv.org/abs/1406.2294 .
+// Jump maps a key to a bucket, i.e. to an index in the list of nodes,
+// so it only moves keys when nodes are added to or removed from the end of the list.
+type Jump struct {
+	hasher    Hasher
+	nodeNames []string
+}
+
+// Hasher is a hash function suitable for general hash-based lookups.
+// Example: xxhash.Sum64
+//
+//	func xxhash.Sum64(b []byte) uint64
+//	Sum64 computes the 64-bit xxHash digest of input.
+type Hasher func(input []byte) uint64
+
+// New creates a new Jump that implements
+// the Jump consistent hashing algorithm.
+func New(hasher Hasher, nodes ...string) (*Jump, error) {
+	memo := make(map[string]struct{}, len(nodes))
+	nodeNames := make([]string, 0, len(nodes))
+
+	for _, node := range nodes {
+		if _, ok := memo[node]; ok {
+			return nil, fmt.Errorf("duplicated node name: %s", node)
+		}
+
+		memo[node] = struct{}{}
+
+		nodeNames = append(nodeNames, node)
+	}
+
+	return &Jump{
+		hasher:    hasher,
+		nodeNames: nodeNames,
+	}, nil
+}
+
+// NodeNames returns the list of node names in the Jump.
+func (j *Jump) NodeNames() []string { return j.nodeNames }
+
+// NodesCount returns the number of nodes in the Jump.
+func (j *Jump) NodesCount() int { return len(j.nodeNames) }
+
+// Get gets the most suitable node name for a key.
+//
+// Use bytesutil.ToUnsafeBytes(str) for fast
+// string => []byte conversion .
+func (j *Jump) Get(key []byte) string {
+	if len(j.nodeNames) == 0 {
+		return ""
+	}
+
+	return j.nodeNames[jumpHash(j.hasher(key), len(j.nodeNames))]
+}
+
+// GetN gets N most suitable node names for a key.
+//
+// Use bytesutil.ToUnsafeBytes(str) for fast
+// string => []byte conversion .
+func (j *Jump) GetN(key []byte, replicasCount int) map[string]struct{} {
+	nodeNames := j.Lookup(key, replicasCount)
+	res := make(map[string]struct{}, len(nodeNames))
+
+	for _, name := range nodeNames {
+		res[name] = struct{}{}
+	}
+
+	return res
+}
+
+// Lookup gets N most suitable node names for a key
+// in the order of preference, i.e. the same nodes as GetN does,
+// but the first one is the primary owner of the key.
+// The replicas are placed on the nodes that follow the primary one.
+func (j *Jump) Lookup(key []byte, replicasCount int) []string {
+	if len(j.nodeNames) == 0 {
+		return []string{}
+	}
+
+	if replicasCount < 1 {
+		replicasCount = 1
+	}
+
+	if replicasCount > len(j.nodeNames) {
+		replicasCount = len(j.nodeNames)
+	}
+
+	idx := jumpHash(j.hasher(key), len(j.nodeNames))
+	res := make([]string, replicasCount)
+
+	for i := 0; i < replicasCount; i++ {
+		res[i] = j.nodeNames[idx]
+		idx++
+
+		if idx >= len(j.nodeNames) {
+			idx = 0
+		}
+	}
+
+	return res
+}
+
+// jumpHash returns the bucket number in the range [0, numBuckets) for the key.
+func jumpHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
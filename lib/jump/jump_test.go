@@ -0,0 +1,168 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jump
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/asokolov365/YamlPartitioner/lib/hrw"
+	"github.com/cespare/xxhash/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func newNodes(n int) []string {
+	nodes := make([]string, n)
+	for i := 0; i < n; i++ {
+		nodes[i] = fmt.Sprintf("node%d", i)
+	}
+
+	return nodes
+}
+
+func TestEmpty(t *testing.T) {
+	t.Parallel()
+
+	j, err := New(xxhash.Sum64)
+	require.NoError(t, err)
+	require.Empty(t, j.Get([]byte("hello")))
+	require.Empty(t, j.GetN([]byte("hello"), 1))
+}
+
+func TestNew_WithDuplicates(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(xxhash.Sum64, "node1", "node2", "node1")
+	require.ErrorContains(t, err, "duplicated node name:")
+}
+
+func TestGetN(t *testing.T) {
+	t.Parallel()
+
+	nodes := newNodes(5)
+
+	j, err := New(xxhash.Sum64, nodes...)
+	require.NoError(t, err)
+	require.Equal(t, nodes, j.NodeNames())
+	require.Equal(t, 5, j.NodesCount())
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+
+		owners := j.Lookup(key, 2)
+		require.Len(t, owners, 2)
+		require.Equal(t, j.Get(key), owners[0])
+		require.NotEqual(t, owners[0], owners[1])
+		require.Len(t, j.GetN(key, 2), 2)
+	}
+
+	require.Len(t, j.GetN([]byte("hello"), 10), 5)
+	require.Len(t, j.GetN([]byte("hello"), 0), 1)
+}
+
+func TestDistributeOver5(t *testing.T) {
+	t.Parallel()
+
+	nodeNum := 5
+
+	j, err := New(xxhash.Sum64, newNodes(nodeNum)...)
+	require.NoError(t, err)
+
+	numKeys := 10000
+	buckets := make(map[string]int, nodeNum)
+
+	for i := 0; i < numKeys; i++ {
+		buckets[j.Get([]byte(fmt.Sprintf("key%d", i)))]++
+	}
+
+	lowerThreshold := int(float32(numKeys) * 0.15)
+	higherThreshold := int(float32(numKeys) * 0.25)
+
+	for n, l := range buckets {
+		require.Less(t, lowerThreshold, l,
+			fmt.Sprintf("%q got less than 15%% of keys: %d < %d", n, l, lowerThreshold))
+		require.Less(t, l, higherThreshold,
+			fmt.Sprintf("%q got more than 25%% of keys: %d > %d", n, l, higherThreshold))
+	}
+}
+
+func TestMovers(t *testing.T) {
+	t.Parallel()
+
+	nodes := newNodes(10)
+
+	j9, err := New(xxhash.Sum64, nodes[:9]...)
+	require.NoError(t, err)
+
+	j10, err := New(xxhash.Sum64, nodes...)
+	require.NoError(t, err)
+
+	numKeys := 10000
+	moved := 0
+
+	for i := 0; i < numKeys; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+
+		n9, n10 := j9.Get(key), j10.Get(key)
+		if n9 != n10 {
+			// Keys only move to the new node.
+			require.Equal(t, "node9", n10)
+
+			moved++
+		}
+	}
+
+	// About 1/10 of keys must move to the new node.
+	require.Less(t, moved, numKeys*15/100)
+	require.Less(t, numKeys*5/100, moved)
+}
+
+func TestSeeded(t *testing.T) {
+	t.Parallel()
+
+	nodes := newNodes(5)
+
+	j, err := New(xxhash.Sum64, nodes...)
+	require.NoError(t, err)
+
+	jEmpty, err := New(Hasher(hrw.Seeded(xxhash.Sum64, "")), nodes...)
+	require.NoError(t, err)
+
+	jSeed1, err := New(Hasher(hrw.Seeded(xxhash.Sum64, "2024-06")), nodes...)
+	require.NoError(t, err)
+
+	jSeed2, err := New(Hasher(hrw.Seeded(xxhash.Sum64, "2024-06")), nodes...)
+	require.NoError(t, err)
+
+	numKeys := 10000
+	moved := 0
+
+	for i := 0; i < numKeys; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+
+		// The empty seed keeps the placement.
+		require.Equal(t, j.Get(key), jEmpty.Get(key))
+		// The same seed gives the same placement.
+		require.Equal(t, jSeed1.Lookup(key, 2), jSeed2.Lookup(key, 2))
+
+		if jSeed1.Get(key) != j.Get(key) {
+			moved++
+		}
+	}
+
+	// About 4/5 of keys must move to another node with the seed.
+	require.Less(t, numKeys*70/100, moved)
+	require.Less(t, moved, numKeys*90/100)
+}
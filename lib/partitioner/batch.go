@@ -0,0 +1,80 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// RunAll runs the given partitioners concurrently,
+// at most parallelism of them at a time (no limit if parallelism < 1).
+//
+// If failFast is false, all partitioners run to completion
+// and all failures are collected.
+// If failFast is true, the first failure cancels the partitioners
+// that are still running and the rest are not started, so only
+// the failures that caused the cancellation are collected.
+func RunAll(ctx context.Context, partitioners []*Partitioner, parallelism int, failFast bool) *Errors {
+	errs := &Errors{}
+
+	g, gctx := errgroup.WithContext(ctx)
+	if !failFast {
+		// The first failure must not cancel the other partitioners.
+		g = &errgroup.Group{}
+		gctx = ctx
+	}
+
+	if parallelism > 0 {
+		g.SetLimit(parallelism)
+	}
+
+	for _, p := range partitioners {
+		if failFast && gctx.Err() != nil && ctx.Err() == nil {
+			break
+		}
+
+		p := p
+
+		g.Go(func() error {
+			// Go blocks until there is a free slot, so the failure
+			// may have canceled the group while waiting for it.
+			if failFast && gctx.Err() != nil && ctx.Err() == nil {
+				return nil
+			}
+
+			err := p.Run(gctx)
+			if err == nil {
+				return nil
+			}
+
+			// Skipping failures caused by the fail fast cancellation,
+			// but not the ones caused by the parent context.
+			if failFast && errors.Is(err, context.Canceled) && ctx.Err() == nil {
+				return nil
+			}
+
+			errs.Add(err)
+
+			return err
+		})
+	}
+
+	_ = g.Wait()
+
+	return errs
+}
@@ -0,0 +1,109 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newBatch returns a partitioner for each input file. Every partitioner
+// has its own working directory, since the same file may be listed twice,
+// and the partitioners run concurrently.
+func newBatch(t *testing.T, inputFiles ...string) []*Partitioner {
+	t.Helper()
+
+	partitioners := make([]*Partitioner, len(inputFiles))
+
+	for i, file := range inputFiles {
+		cfg, err := NewConfig(
+			WithConsistentHashing(getConsistentHashing()),
+			WithReplicasCount(2),
+			WithSplitPoint("groups.*.rules"),
+			WithWorkingDirectory(t.TempDir()),
+		)
+		require.NoError(t, err)
+
+		inputFile, err := filepath.Abs(file)
+		require.NoError(t, err)
+
+		partitioners[i], err = WithConfig(cfg, inputFile, "")
+		require.NoError(t, err)
+	}
+
+	return partitioners
+}
+
+func TestRunAll_KeepGoing(t *testing.T) {
+	t.Parallel()
+
+	partitioners := newBatch(t,
+		"../../testdata/rules/not-found-1.yaml",
+		"../../testdata/rules/kube-good.yaml",
+		"../../testdata/rules/not-found-2.yaml",
+		"../../testdata/rules/kube-good.yaml",
+	)
+
+	errs := RunAll(context.Background(), partitioners, 2, false)
+	require.Equal(t, 2, errs.Len())
+
+	list := errs.List()
+	require.Equal(t, partitioners[0].inputFile, list[0].File)
+	require.Equal(t, partitioners[2].inputFile, list[1].File)
+	require.Equal(t, "groups.*.rules", list[0].SplitPoint)
+	require.ErrorContains(t, list[0], "failed to read input file")
+
+	// The good files are partitioned regardless of the failures.
+	require.Equal(t, 160, partitioners[1].totalItemsBefore)
+	require.Equal(t, 160, partitioners[3].totalItemsBefore)
+}
+
+func TestRunAll_FailFast(t *testing.T) {
+	t.Parallel()
+
+	partitioners := newBatch(t,
+		"../../testdata/rules/not-found-1.yaml",
+		"../../testdata/rules/kube-good.yaml",
+		"../../testdata/rules/kube-good.yaml",
+	)
+
+	errs := RunAll(context.Background(), partitioners, 1, true)
+	require.Equal(t, 1, errs.Len())
+	require.Equal(t, partitioners[0].inputFile, errs.List()[0].File)
+
+	// The rest of files are not started after the first failure.
+	require.Nil(t, partitioners[1].ShardItemsCount())
+	require.Nil(t, partitioners[2].ShardItemsCount())
+}
+
+func TestRunAll_ParentCanceled(t *testing.T) {
+	t.Parallel()
+
+	partitioners := newBatch(t,
+		"../../testdata/rules/kube-good.yaml",
+		"../../testdata/rules/kube-good.yaml",
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The failures caused by the parent context are always reported.
+	errs := RunAll(ctx, partitioners, 0, true)
+	require.Positive(t, errs.Len())
+	require.ErrorIs(t, errs, context.Canceled)
+}
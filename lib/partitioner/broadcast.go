@@ -0,0 +1,46 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import "gopkg.in/yaml.v3"
+
+// WithBroadcast sets the selectors of the items kept on every shard,
+// e.g. the watchdog alerts every instance must evaluate.
+// The matching items bypass consistent hashing and are counted
+// separately in the shard reports, see Partitioner.ShardBroadcastCount.
+// The directives and the routes take precedence over the broadcast.
+func WithBroadcast(selectors ...*Selector) Option {
+	return func(c *Config) error {
+		c.broadcast = selectors
+		return nil
+	}
+}
+
+// Broadcast returns the broadcast selectors, see WithBroadcast.
+func (c *Config) Broadcast() []*Selector {
+	return c.broadcast
+}
+
+// broadcasts reports whether the item matches any broadcast selector.
+// key is nil for items of a SequenceNode.
+func (c *Config) broadcasts(key, item *yaml.Node) bool {
+	for _, sel := range c.broadcast {
+		if sel.Match(key, item) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_Broadcast(t *testing.T) {
+	t.Parallel()
+
+	input := `groups:
+  - name: health
+    rules:
+      - alert: Watchdog
+        expr: vector(1)
+      - alert: Heartbeat
+        expr: vector(1)
+  - name: nodes
+    rules:
+      - alert: NodeDown
+        expr: up == 0
+      - alert: NodeHeartbeat
+        expr: node_heartbeat == 0
+      - alert: DiskFull
+        expr: disk_free == 0
+`
+
+	f := func(splitPoint, input string, selectors ...string) (*Partitioner, string) {
+		t.Helper()
+
+		routes, err := ParseRoutes([]byte("routes:\n  - match: alert=NodeHeartbeat\n    shards: [beta]\n"))
+		require.NoError(t, err)
+
+		return runInput(t, "input.yml", input,
+			WithSplitPoint(splitPoint),
+			WithRoutes(routes...),
+			WithBroadcast(parseSelectors(t, selectors...)...),
+		)
+	}
+
+	p, workDir := f("groups.*.rules", input, "alert=~.*Heartbeat", "alert=Watchdog")
+
+	require.Contains(t, p.Report(), "Broadcast 2 items to every shard\n")
+
+	total := 0
+
+	for _, name := range shardNames {
+		// The route takes precedence over the broadcast for NodeHeartbeat.
+		require.Equal(t, 2, p.ShardBroadcastCount()[name], name)
+		require.Contains(t, p.Report(), `, 2 of them broadcast`)
+
+		output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+		require.NoError(t, err)
+		require.Contains(t, string(output), "alert: Watchdog")
+		require.Contains(t, string(output), "alert: Heartbeat")
+
+		total += p.ShardItemsCount()[name]
+	}
+
+	// 2 broadcast items in 5 shards, and 3 items in a single shard each.
+	require.Equal(t, 13, total)
+
+	locations, err := p.Locate(context.Background(), MatchAll)
+	require.NoError(t, err)
+	require.Len(t, locations, 5)
+
+	for i, expected := range []bool{true, true, false, false, false} {
+		require.Equal(t, expected, locations[i].Broadcast, locations[i].Path)
+	}
+
+	require.Equal(t, shardNames, locations[0].Owners)
+	require.Equal(t, []string{"beta"}, locations[3].Owners)
+
+	// No broadcast items.
+	p, _ = f("groups.*.rules", input)
+
+	require.NotContains(t, p.Report(), "broadcast")
+	require.NotContains(t, p.Report(), "Broadcast")
+
+	for _, name := range shardNames {
+		require.Zero(t, p.ShardBroadcastCount()[name])
+	}
+
+	// The lines are broadcast in the same way.
+	p, _ = f(SplitLines, "watchdog\na\nb\nc\n", "@value=watchdog")
+
+	for _, name := range shardNames {
+		require.Equal(t, 1, p.ShardBroadcastCount()[name], name)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // ConsistentHashing represent an abstract interface for consistent hashing.
@@ -33,6 +34,13 @@ type ConsistentHashing interface {
 	GetN(key []byte, replicasCount int) map[string]struct{}
 }
 
+// orderedHashing is implemented by ConsistentHashing algorithms
+// that can tell the order of preference of nodes for a key, e.g. hrw.Rendezvous.
+type orderedHashing interface {
+	// Lookup gets N most suitable node names for a key in the order of preference
+	Lookup(key []byte, replicasCount int) []string
+}
+
 // Config defines common configuration for yaml partitioning.
 // Config must be immutable.
 type Config struct {
@@ -42,6 +50,29 @@ type Config struct {
 	thisShardID       int
 	replicasCount     int
 	resultYamlIndent  int
+	timeout           time.Duration
+	// format is the format of all input files,
+	// it is detected by the file extension if empty.
+	format string
+	// mergeKeys is the merge key policy, see WithMergeKeys.
+	mergeKeys string
+	// pruneEmpty is the empty containers policy, see WithPruneEmpty.
+	pruneEmpty string
+	// inputRoot is the directory the keys of the input files
+	// are relative to for the SplitFile split point, see WithInputRoot.
+	inputRoot string
+	// drop are the selectors of the items removed from every shard, see WithDrop.
+	drop []*Selector
+	// routes are evaluated before consistent hashing, see WithRoutes.
+	routes []*Route
+	// broadcast are the selectors of the items kept on every shard, see WithBroadcast.
+	broadcast []*Selector
+	// replicationOverrides set the replication factor of the matching items,
+	// see WithReplicationOverrides.
+	replicationOverrides []*ReplicationOverride
+	// stripDirectives is true if the directives are removed
+	// from the output, see WithStripDirectives.
+	stripDirectives bool
 }
 
 // NodesCount returns the number of nodes in the ConsistentHashing.
@@ -49,11 +80,43 @@ func (c *Config) NodesCount() int {
 	return c.consistentHashing.NodesCount()
 }
 
+// maxReplicasCount returns the biggest replication factor allowed
+// for the config, the replication overrides and the directives,
+// i.e. half the number of nodes.
+func (c *Config) maxReplicasCount() int {
+	return c.NodesCount() / 2
+}
+
 // NodeNames returns names of nodes in the ConsistentHashing.
 func (c *Config) NodeNames() []string {
 	return c.consistentHashing.NodeNames()
 }
 
+// Owners returns names of the shards that get an item with the given
+// consistent hashing key. The names are in the order of preference
+// if the ConsistentHashing supports it, otherwise in the NodeNames order.
+func (c *Config) Owners(key []byte) []string {
+	return c.ownersN(key, c.replicasCount)
+}
+
+// ownersN is Owners with the given replication factor.
+func (c *Config) ownersN(key []byte, replicasCount int) []string {
+	if h, ok := c.consistentHashing.(orderedHashing); ok {
+		return h.Lookup(key, replicasCount)
+	}
+
+	nodeNames := c.consistentHashing.GetN(key, replicasCount)
+	owners := make([]string, 0, len(nodeNames))
+
+	for _, name := range c.NodeNames() {
+		if _, ok := nodeNames[name]; ok {
+			owners = append(owners, name)
+		}
+	}
+
+	return owners
+}
+
 // WorkDir returns the working directory (temp dir).
 func (c *Config) WorkDir() string {
 	return c.workDir
@@ -80,6 +143,7 @@ func (c *Config) WorkDir() string {
 //	WithResultYamlIndent(2),
 //	WithSplitPoint("groups.*.rules"),
 //	WithThisShardID(-1),
+//	WithTimeout(10*time.Second),
 //
 // )
 // .
@@ -89,6 +153,9 @@ func NewConfig(opts ...Option) (*Config, error) {
 		resultYamlIndent: 2,
 		thisShardID:      -1,
 		workDir:          os.TempDir(),
+		timeout:          10 * time.Second,
+		mergeKeys:        MergeKeysKeep,
+		pruneEmpty:       PruneEmptyNone,
 	}
 
 	for _, opt := range opts {
@@ -102,7 +169,8 @@ func NewConfig(opts ...Option) (*Config, error) {
 		return nil, fmt.Errorf("consistent hashing is not set")
 	}
 
-	if cfg.splitPoint == nil || len(cfg.splitPoint.slice) == 0 || len(cfg.splitPoint.str) == 0 {
+	// The SplitRoot split point has an empty path.
+	if cfg.splitPoint == nil || len(cfg.splitPoint.str) == 0 {
 		return nil, fmt.Errorf("split point path is not set")
 	}
 
@@ -116,10 +184,18 @@ func NewConfig(opts ...Option) (*Config, error) {
 		return nil, fmt.Errorf("this shard id is out of range")
 	}
 
-	if cfg.replicasCount > (shardsCount / 2) {
+	if cfg.replicasCount > cfg.maxReplicasCount() {
 		return nil, fmt.Errorf("replication factor is too big")
 	}
 
+	if err := cfg.validateRoutes(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validateReplicationOverrides(); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
@@ -152,7 +228,10 @@ func WithConsistentHashing(h ConsistentHashing) Option {
 // and starts sharding items from there.
 // Note: the SplitPoint yaml Node Kind must be either a SequenceNode (list)
 // or a MappingNode (map).
-// The SplitPoint must be in format "<key>", "<key>.*.<key>"
+// The SplitPoint must be in format "<key>", "<key>.*.<key>",
+// SplitDocuments to make each document of a YAML stream an item,
+// SplitFile to make each input file an item, or SplitLines and SplitCSV
+// to make each line or record of a plain text input file an item.
 // REQUIRED .
 func WithSplitPoint(s string) Option {
 	sp, err := newSplitPoint(s)
@@ -204,6 +283,96 @@ func WithResultYamlIndent(i int) Option {
 	}
 }
 
+// WithFormat sets the format of all input files: FormatYAML,
+// FormatJSON or FormatTOML. This defaults to "auto",
+// meaning that the format is detected by the file extension, see DetectFormat.
+func WithFormat(format string) Option {
+	return func(c *Config) error {
+		switch format {
+		case "auto", "":
+			c.format = ""
+		case FormatYAML, FormatJSON, FormatTOML:
+			c.format = format
+		default:
+			return fmt.Errorf("unknown format: %q", format)
+		}
+
+		return nil
+	}
+}
+
+// WithMergeKeys sets the policy for the YAML merge keys ("<<")
+// of a MappingNode split point: MergeKeysKeep or MergeKeysExpand.
+// The merge keys below the split point are kept as is.
+// Note: with MergeKeysKeep a merged key overridden by an item
+// that went to another shard is not overridden in this shard.
+// This defaults to MergeKeysKeep.
+func WithMergeKeys(policy string) Option {
+	return func(c *Config) error {
+		switch policy {
+		case MergeKeysKeep, MergeKeysExpand:
+			c.mergeKeys = policy
+		default:
+			return fmt.Errorf("unknown merge keys policy: %q", policy)
+		}
+
+		return nil
+	}
+}
+
+// Empty containers policies, see WithPruneEmpty.
+const (
+	// PruneEmptyNone keeps the containers of the split point nodes
+	// which got no items, e.g. "- name: x\n  rules: []".
+	PruneEmptyNone = "none"
+	// PruneEmptyParent removes the containers of the split point nodes
+	// which got no items, e.g. the whole group for "groups.*.rules".
+	PruneEmptyParent = "parent"
+	// PruneEmptyAncestors removes the containers as PruneEmptyParent does,
+	// along with their ancestors left empty, e.g. "groups: []".
+	PruneEmptyAncestors = "ancestors"
+)
+
+// WithPruneEmpty sets the policy for the containers of the split point
+// nodes, which got no items in a shard: PruneEmptyNone, PruneEmptyParent
+// or PruneEmptyAncestors. The document root is never removed.
+// This defaults to PruneEmptyNone.
+func WithPruneEmpty(policy string) Option {
+	return func(c *Config) error {
+		switch policy {
+		case PruneEmptyNone, PruneEmptyParent, PruneEmptyAncestors:
+			c.pruneEmpty = policy
+		default:
+			return fmt.Errorf("unknown prune empty policy: %q", policy)
+		}
+
+		return nil
+	}
+}
+
+// formatOf returns the format of the input file.
+func (c *Config) formatOf(path string) string {
+	if len(c.format) > 0 {
+		return c.format
+	}
+
+	return DetectFormat(path)
+}
+
+// WithTimeout sets the max duration of partitioning of a single input file.
+// Zero or negative duration means no deadline.
+// This defaults to 10s.
+func WithTimeout(d time.Duration) Option {
+	if d < 0 {
+		d = 0
+	}
+
+	return func(c *Config) error {
+		c.timeout = d
+		return nil
+	}
+}
+
 // WithWorkingDirectory sets the directory where.
 // This defaults to os.TempDir() .
 func WithWorkingDirectory(path string) Option {
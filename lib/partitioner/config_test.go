@@ -51,3 +51,32 @@ func TestShard_ReplicationTooBig(t *testing.T) {
 	)
 	require.ErrorContains(t, err, "replication factor is too big")
 }
+
+func TestConfig_Format(t *testing.T) {
+	t.Parallel()
+
+	f := func(format, path, expected string) {
+		t.Helper()
+
+		cfg, err := NewConfig(
+			WithConsistentHashing(getConsistentHashing()),
+			WithSplitPoint("*"),
+			WithFormat(format),
+		)
+		require.NoError(t, err)
+		require.Equal(t, expected, cfg.formatOf(path))
+	}
+
+	f("auto", "rules.yml", FormatYAML)
+	f("auto", "dashboards/overview.JSON", FormatJSON)
+	f("auto", "blackbox.toml", FormatTOML)
+	f("auto", "targets", FormatYAML)
+	f(FormatJSON, "rules.yml", FormatJSON)
+
+	_, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithSplitPoint("*"),
+		WithFormat("xml"),
+	)
+	require.ErrorContains(t, err, `unknown format: "xml"`)
+}
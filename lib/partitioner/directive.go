@@ -0,0 +1,242 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Placement directives, which are read from the comments of the items,
+// e.g. "# yp:pin=instance.2" above an item or "- alert: X # yp:all".
+const (
+	// DirectiveAll places the item on every shard.
+	DirectiveAll = "yp:all"
+	// DirectiveSkip drops the item from every shard.
+	DirectiveSkip = "yp:skip"
+	// DirectivePin places the item on the given shards, e.g. "yp:pin=instance.2,instance.3".
+	DirectivePin = "yp:pin"
+	// DirectiveRF sets the replication factor of the item, e.g. "yp:rf=3".
+	DirectiveRF = "yp:rf"
+	// DirectiveKey sets the consistent hashing key of the item, e.g. "yp:key=payments",
+	// so the items with the same key go to the same shards.
+	DirectiveKey = "yp:key"
+)
+
+// directivePrefix starts a comment line with the directives.
+const directivePrefix = "yp:"
+
+// directives are the placement directives of an item.
+type directives struct {
+	// placement is DirectiveAll, DirectiveSkip, DirectivePin or empty.
+	placement string
+	pin       []string
+	rf        int
+	key       []byte
+}
+
+// directiveComments returns the comments of the item, which may hold
+// its directives: the head and line comments of the key and of the item,
+// and the comments of the nodes on the first line of the item,
+// e.g. "- alert: X # yp:all". key is nil for items of a SequenceNode.
+func directiveComments(key, item *yaml.Node) []*string {
+	var comments []*string
+
+	if key != nil {
+		comments = append(comments, &key.HeadComment, &key.LineComment)
+	}
+
+	comments = append(comments, &item.HeadComment, &item.LineComment)
+
+	var firstLine func(node *yaml.Node)
+
+	firstLine = func(node *yaml.Node) {
+		for _, child := range node.Content {
+			if child.Line != item.Line {
+				return
+			}
+
+			comments = append(comments, &child.HeadComment, &child.LineComment)
+
+			firstLine(child)
+		}
+	}
+
+	firstLine(item)
+
+	return comments
+}
+
+// parseDirectives parses the directives of the item found in its comments,
+// see directiveComments. A comment line holds directives if it starts with
+// "yp:", several directives of a line are separated with spaces.
+// Note: the directives of the alias items are ignored, since they follow their anchors.
+func (c *Config) parseDirectives(key, item *yaml.Node) (*directives, error) {
+	var d *directives
+
+	for _, comment := range directiveComments(key, item) {
+		for _, line := range strings.Split(*comment, "\n") {
+			fields := directiveFields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			if d == nil {
+				d = &directives{}
+			}
+
+			for _, field := range fields {
+				if err := c.parseDirective(d, field); err != nil {
+					return nil, fmt.Errorf("invalid directive %q at line %d: %w", field, item.Line, err)
+				}
+			}
+		}
+	}
+
+	if d != nil && len(d.placement) > 0 && (d.rf > 0 || d.key != nil) {
+		return nil, fmt.Errorf("invalid directives at line %d: %s conflicts with %s and %s",
+			item.Line, d.placement, DirectiveRF, DirectiveKey)
+	}
+
+	return d, nil
+}
+
+// directiveFields returns the directives of the comment line, if any.
+func directiveFields(line string) []string {
+	line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+	if !strings.HasPrefix(line, directivePrefix) {
+		return nil
+	}
+
+	return strings.Fields(line)
+}
+
+func (c *Config) parseDirective(d *directives, field string) error {
+	name, value, hasValue := strings.Cut(field, "=")
+
+	setPlacement := func(placement string) error {
+		if len(d.placement) > 0 {
+			return fmt.Errorf("conflicts with %s", d.placement)
+		}
+
+		d.placement = placement
+
+		return nil
+	}
+
+	switch name {
+	case DirectiveAll, DirectiveSkip:
+		if hasValue {
+			return fmt.Errorf("unexpected value")
+		}
+
+		return setPlacement(name)
+
+	case DirectivePin:
+		if len(value) == 0 {
+			return fmt.Errorf("no shards")
+		}
+
+		known := make(map[string]struct{}, c.NodesCount())
+		for _, shard := range c.NodeNames() {
+			known[shard] = struct{}{}
+		}
+
+		for _, shard := range strings.Split(value, ",") {
+			if _, ok := known[shard]; !ok {
+				return fmt.Errorf("unknown shard %q", shard)
+			}
+
+			d.pin = append(d.pin, shard)
+		}
+
+		return setPlacement(name)
+
+	case DirectiveRF:
+		if d.rf > 0 {
+			return fmt.Errorf("duplicate directive")
+		}
+
+		rf, err := strconv.Atoi(value)
+		if err != nil || rf < 1 || rf > c.maxReplicasCount() {
+			return fmt.Errorf("replication factor must be from 1 to %d", c.maxReplicasCount())
+		}
+
+		d.rf = rf
+
+	case DirectiveKey:
+		if d.key != nil {
+			return fmt.Errorf("duplicate directive")
+		}
+
+		if len(value) == 0 {
+			return fmt.Errorf("empty key")
+		}
+
+		d.key = []byte(value)
+
+	default:
+		return fmt.Errorf("unknown directive")
+	}
+
+	return nil
+}
+
+// stripDirectives removes the comment lines with the directives
+// from the comments of the item, see directiveComments.
+func stripDirectives(key, item *yaml.Node) {
+	for _, comment := range directiveComments(key, item) {
+		if !strings.Contains(*comment, directivePrefix) {
+			continue
+		}
+
+		lines := strings.Split(*comment, "\n")
+		kept := lines[:0]
+
+		for _, line := range lines {
+			if len(directiveFields(line)) == 0 {
+				kept = append(kept, line)
+			}
+		}
+
+		*comment = strings.Join(kept, "\n")
+	}
+}
+
+// hasDirectiveComments reports whether any of the comments
+// of the item holds directives, see directiveComments.
+func hasDirectiveComments(key, item *yaml.Node) bool {
+	for _, comment := range directiveComments(key, item) {
+		for _, line := range strings.Split(*comment, "\n") {
+			if len(directiveFields(line)) > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// WithStripDirectives makes the partitioner remove the comments
+// with the placement directives from the output, see DirectiveAll.
+func WithStripDirectives(strip bool) Option {
+	return func(c *Config) error {
+		c.stripDirectives = strip
+		return nil
+	}
+}
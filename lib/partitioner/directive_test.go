@@ -0,0 +1,174 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDirectives(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithSplitPoint("rules"),
+	)
+	require.NoError(t, err)
+
+	f := func(input string, expected *directives) {
+		t.Helper()
+
+		var doc yaml.Node
+
+		err := yaml.Unmarshal([]byte(input), &doc)
+		require.NoError(t, err)
+
+		rules := doc.Content[0].Content[1]
+
+		d, err := cfg.parseDirectives(nil, rules.Content[0])
+		require.NoError(t, err)
+		require.Equal(t, expected, d)
+	}
+
+	f("rules:\n  - alert: A", nil)
+	f("rules:\n  # Not a directive: yp:all\n  - alert: A", nil)
+	f("rules:\n  # yp:all\n  - alert: A", &directives{placement: DirectiveAll})
+	f("rules:\n  - alert: A # yp:skip", &directives{placement: DirectiveSkip})
+	f("rules:\n  - # yp:pin=gamma,alpha\n    alert: A", &directives{placement: DirectivePin, pin: []string{"gamma", "alpha"}})
+	f("rules:\n  # The payments rules.\n  # yp:rf=2 yp:key=payments\n  - alert: A", &directives{rf: 2, key: []byte("payments")})
+	// The directives below the first line of the item are not its directives.
+	f("rules:\n  - alert: A\n    expr: x # yp:all", nil)
+
+	invalid := func(input, expected string) {
+		t.Helper()
+
+		var doc yaml.Node
+
+		err := yaml.Unmarshal([]byte(input), &doc)
+		require.NoError(t, err)
+
+		rules := doc.Content[0].Content[1]
+
+		_, err = cfg.parseDirectives(nil, rules.Content[0])
+		require.ErrorContains(t, err, expected)
+	}
+
+	invalid("rules:\n  - alert: A # yp:everywhere", `invalid directive "yp:everywhere" at line 2: unknown directive`)
+	invalid("rules:\n  - alert: A # yp:all=1", `invalid directive "yp:all=1" at line 2: unexpected value`)
+	invalid("rules:\n  # yp:all\n  - alert: A # yp:skip", `invalid directive "yp:skip" at line 3: conflicts with yp:all`)
+	invalid("rules:\n  - alert: A # yp:pin=prom.3", `unknown shard "prom.3"`)
+	invalid("rules:\n  - alert: A # yp:pin=", "no shards")
+	invalid("rules:\n  - alert: A # yp:rf=0", "replication factor must be from 1 to 2")
+	// The limit is the same as for the replication factor of the config.
+	invalid("rules:\n  - alert: A # yp:rf=3", "replication factor must be from 1 to 2")
+	invalid("rules:\n  - alert: A # yp:rf=2 yp:rf=3", "duplicate directive")
+	invalid("rules:\n  - alert: A # yp:key=", "empty key")
+	invalid("rules:\n  - alert: A # yp:all yp:rf=2", "yp:all conflicts with yp:rf and yp:key")
+}
+
+func TestRun_Directives(t *testing.T) {
+	t.Parallel()
+
+	input := `rules:
+  # Broadcast.
+  # yp:all
+  - alert: A
+  - alert: B # yp:skip
+  - # yp:pin=gamma
+    alert: C
+  - alert: D # yp:rf=2
+  # yp:key=payments
+  - alert: E
+  # yp:key=payments
+  - alert: F
+`
+
+	f := func(strip bool) (*Partitioner, string) {
+		t.Helper()
+
+		return runInput(t, "rules.yml", input,
+			WithReplicasCount(1),
+			WithSplitPoint("rules"),
+			WithStripDirectives(strip),
+		)
+	}
+
+	p, workDir := f(false)
+
+	require.Equal(t, map[string]int{DirectiveAll: 1, DirectiveSkip: 1, DirectivePin: 1}, p.DirectiveItemsCount())
+	require.Contains(t, p.Report(), `Directive "yp:all" placed 1 items`)
+
+	locations, err := p.Locate(context.Background(), MatchAll)
+	require.NoError(t, err)
+	require.Len(t, locations, 6)
+
+	require.Equal(t, shardNames, locations[0].Owners)
+	require.Empty(t, locations[1].Owners)
+	require.Equal(t, []string{"gamma"}, locations[2].Owners)
+	require.Len(t, locations[3].Owners, 2)
+	// The items with the same key go to the same shards.
+	require.Equal(t, locations[4].Owners, locations[5].Owners)
+	require.Equal(t, []byte("payments"), locations[4].Key)
+
+	total := 0
+
+	for _, name := range shardNames {
+		count := p.ShardItemsCount()[name]
+		total += count
+
+		if count == 0 {
+			continue
+		}
+
+		output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+		require.NoError(t, err)
+		require.Contains(t, string(output), "# yp:all")
+		require.NotContains(t, string(output), "alert: B")
+	}
+
+	// A goes to 5 shards, B to none, C to 1, D to 2, E and F to 1 each.
+	require.Equal(t, 10, total)
+
+	p, workDir = f(true)
+
+	for _, name := range shardNames {
+		if p.ShardItemsCount()[name] == 0 {
+			continue
+		}
+
+		output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+		require.NoError(t, err)
+		require.NotContains(t, string(output), "yp:")
+		require.Contains(t, string(output), "# Broadcast.\n  - alert: A\n")
+	}
+}
+
+func TestRun_DirectivesInvalid(t *testing.T) {
+	t.Parallel()
+
+	p, _ := newInputPartitioner(t, "rules.yml", "rules:\n  - alert: A\n  - alert: B # yp:pin=prom.3\n",
+		WithSplitPoint("rules"),
+	)
+
+	err := p.Run(context.Background())
+	require.ErrorContains(t, err, p.inputFile)
+	require.ErrorContains(t, err, `invalid directive "yp:pin=prom.3" at line 3: unknown shard "prom.3"`)
+}
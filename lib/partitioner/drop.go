@@ -0,0 +1,46 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import "gopkg.in/yaml.v3"
+
+// WithDrop sets the selectors of the items removed from every shard,
+// e.g. "labels.env=staging" to disable the staging rules in production
+// without a forked copy of the input. The matching items are dropped
+// before anything else, so they are not counted as the items found
+// at the split point, but reported separately.
+func WithDrop(selectors ...*Selector) Option {
+	return func(c *Config) error {
+		c.drop = selectors
+		return nil
+	}
+}
+
+// Drop returns the drop selectors, see WithDrop.
+func (c *Config) Drop() []*Selector {
+	return c.drop
+}
+
+// dropSelector returns the first drop selector matching the item, or nil.
+// key is nil for items of a SequenceNode.
+func (c *Config) dropSelector(key, item *yaml.Node) *Selector {
+	for _, sel := range c.drop {
+		if sel.Match(key, item) {
+			return sel
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_Drop(t *testing.T) {
+	t.Parallel()
+
+	input := `groups:
+  - name: staging
+    rules:
+      - &staging
+        alert: StagingDown
+        labels:
+          env: staging
+      - alert: ProdDown
+        labels:
+          env: prod
+      # yp:all
+      - alert: StagingLatency
+        labels:
+          env: staging
+  - name: aliases
+    rules:
+      - *staging
+`
+
+	f := func(splitPoint, input string, selectors ...string) *Partitioner {
+		t.Helper()
+
+		p, _ := runInput(t, "input.yml", input,
+			WithSplitPoint(splitPoint),
+			WithDrop(parseSelectors(t, selectors...)...),
+		)
+
+		return p
+	}
+
+	p := f("groups.*.rules", input, "labels.env=staging")
+
+	// The drop selectors take precedence over the directives,
+	// and the alias of the dropped item is dropped as well.
+	require.Equal(t, 2, p.DroppedItemsCount())
+	require.Contains(t, p.Report(), `Found 1 items at path "groups.*.rules"`)
+	require.Contains(t, p.Report(), "Dropped 2 items matching the drop selectors\n")
+	require.Contains(t, p.Report(), `Dropped the item at line 4 matching "labels.env=staging"`)
+	require.Contains(t, p.Report(), `Dropped the item at line 12 matching "labels.env=staging"`)
+	require.NotContains(t, p.Report(), "yp:all")
+
+	total := 0
+	for _, count := range p.ShardItemsCount() {
+		total += count
+	}
+
+	require.Equal(t, 1, total)
+
+	locations, err := p.Locate(context.Background(), MatchAll)
+	require.NoError(t, err)
+	require.Len(t, locations, 3)
+
+	for i, expected := range []bool{true, false, true} {
+		require.Equal(t, expected, locations[i].Dropped, locations[i].Path)
+	}
+
+	require.Empty(t, locations[0].Owners)
+
+	// No drop selectors.
+	p = f("groups.*.rules", input)
+
+	require.Zero(t, p.DroppedItemsCount())
+	require.Contains(t, p.Report(), `Found 4 items at path "groups.*.rules"`)
+	require.NotContains(t, p.Report(), "Dropped")
+
+	// The lines are dropped in the same way.
+	p = f(SplitLines, "a\nstaging-a\nb\nstaging-b\n", "@value=~staging-.*")
+
+	require.Equal(t, 2, p.DroppedItemsCount())
+	require.Contains(t, p.Report(), "Found 2 items")
+	require.Contains(t, p.Report(), `Dropped the item at line 4 matching "@value=~staging-.*"`)
+}
+
+func TestRun_DropPruneEmpty(t *testing.T) {
+	t.Parallel()
+
+	input := `groups:
+  - name: staging
+    rules:
+      - alert: StagingDown
+        labels:
+          env: staging
+      - alert: StagingLatency
+        labels:
+          env: staging
+  - name: prod
+    rules:
+      - alert: ProdDown
+        labels:
+          env: prod
+`
+
+	p, workDir := runInput(t, "input.yml", input,
+		WithSplitPoint("groups.*.rules"),
+		WithDrop(parseSelectors(t, "labels.env=staging")...),
+		WithPruneEmpty(PruneEmptyParent),
+	)
+
+	require.Equal(t, 2, p.DroppedItemsCount())
+
+	// The group left without items by dropping is pruned from every shard
+	// instead of being kept as an empty sequence.
+	for _, name := range shardNames {
+		output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		require.NoError(t, err)
+		require.NotContains(t, string(output), "staging", name)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Error describes a failure of partitioning of a single input file.
+type Error struct {
+	// File is the input file, it is empty if the failure
+	// is not specific to a file.
+	File string
+	// SplitPoint is the split point path.
+	SplitPoint string
+	// Shard is the shard being written, it is empty if the failure
+	// is not specific to a shard, e.g. the input file is not a valid YAML.
+	Shard string
+	// Err is the cause of the failure.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if len(e.File) == 0 && len(e.Shard) == 0 {
+		return e.Err.Error()
+	}
+
+	var b strings.Builder
+
+	b.WriteString("failed to partition")
+
+	if len(e.File) > 0 {
+		fmt.Fprintf(&b, " %q", e.File)
+	}
+
+	if len(e.SplitPoint) > 0 {
+		fmt.Fprintf(&b, " at %q", e.SplitPoint)
+	}
+
+	if len(e.Shard) > 0 {
+		fmt.Fprintf(&b, " for shard %q", e.Shard)
+	}
+
+	b.WriteString(": ")
+	b.WriteString(e.Err.Error())
+
+	return b.String()
+}
+
+// Unwrap returns the cause of the failure.
+func (e *Error) Unwrap() error { return e.Err }
+
+// Errors collects the errors of partitioning of multiple input files.
+// Errors is safe for concurrent use.
+type Errors struct {
+	mu   sync.Mutex
+	errs []*Error
+}
+
+// Add adds err to the collection. nil err is ignored,
+// err which is not *Error is added as an Error with the Err field only.
+func (e *Errors) Add(err error) {
+	if err == nil {
+		return
+	}
+
+	var pErr *Error
+	if !errors.As(err, &pErr) {
+		pErr = &Error{Err: err}
+	}
+
+	e.mu.Lock()
+	e.errs = append(e.errs, pErr)
+	e.mu.Unlock()
+}
+
+// Len returns the number of the collected errors.
+func (e *Errors) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.errs)
+}
+
+// List returns the collected errors sorted by file and shard.
+func (e *Errors) List() []*Error {
+	e.mu.Lock()
+	list := make([]*Error, len(e.errs))
+	copy(list, e.errs)
+	e.mu.Unlock()
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].File != list[j].File {
+			return list[i].File < list[j].File
+		}
+
+		return list[i].Shard < list[j].Shard
+	})
+
+	return list
+}
+
+// Error implements the error interface.
+func (e *Errors) Error() string {
+	list := e.List()
+
+	lines := make([]string, len(list))
+	for i, err := range list {
+		lines[i] = fmt.Sprintf("[!] %s", err.Error())
+	}
+
+	return fmt.Sprintf("partitioning finished with %d error(s):\n%s",
+		len(list), strings.Join(lines, "\n"))
+}
+
+// Unwrap returns the collected errors,
+// so errors.Is and errors.As look through all of them.
+func (e *Errors) Unwrap() []error {
+	list := e.List()
+
+	errs := make([]error, len(list))
+	for i, err := range list {
+		errs[i] = err
+	}
+
+	return errs
+}
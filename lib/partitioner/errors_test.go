@@ -0,0 +1,85 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestError(t *testing.T) {
+	t.Parallel()
+
+	f := func(err *Error, expected string) {
+		t.Helper()
+		require.Equal(t, expected, err.Error())
+	}
+
+	cause := errors.New("oops")
+
+	f(&Error{Err: cause}, "oops")
+	f(&Error{File: "a.yml", SplitPoint: "groups.*.rules", Err: cause},
+		`failed to partition "a.yml" at "groups.*.rules": oops`)
+	f(&Error{File: "a.yml", SplitPoint: "groups.*.rules", Shard: "alpha", Err: cause},
+		`failed to partition "a.yml" at "groups.*.rules" for shard "alpha": oops`)
+
+	require.ErrorIs(t, &Error{File: "a.yml", Err: cause}, cause)
+}
+
+func TestErrors_ConcurrentAdd(t *testing.T) {
+	t.Parallel()
+
+	var (
+		errs Errors
+		wg   sync.WaitGroup
+	)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			if i%10 == 0 {
+				errs.Add(context.DeadlineExceeded)
+				return
+			}
+
+			errs.Add(&Error{
+				File: fmt.Sprintf("%03d.yml", i),
+				Err:  errors.New("oops"),
+			})
+		}(i)
+	}
+
+	errs.Add(nil)
+	wg.Wait()
+
+	require.Equal(t, 100, errs.Len())
+	require.ErrorIs(t, &errs, context.DeadlineExceeded)
+	require.ErrorContains(t, &errs, "partitioning finished with 100 error(s):\n[!] ")
+
+	list := errs.List()
+	require.Len(t, list, 100)
+	// Errors without the file go first.
+	require.Empty(t, list[0].File)
+	require.Equal(t, "001.yml", list[10].File)
+	require.Equal(t, "099.yml", list[99].File)
+}
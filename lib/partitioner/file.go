@@ -0,0 +1,146 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileKey returns the consistent hashing key of the input file
+// for the SplitFile split point given its relative path,
+// e.g. "team-a/blackbox.yml", so the file goes to the same shards
+// wherever the input directory is. See WithInputRoot.
+func FileKey(relPath string) []byte {
+	return []byte(strings.TrimPrefix(filepath.ToSlash(relPath), "/"))
+}
+
+// WithInputRoot sets the directory the keys of the input files
+// are relative to for the SplitFile split point, e.g. the base directory
+// of the input pattern. The keys do not depend on the other input files then,
+// otherwise the key is the path of the output file, which is relative to
+// the common path of all input files, see WithConfig.
+func WithInputRoot(dir string) Option {
+	return func(c *Config) error {
+		c.inputRoot = dir
+		return nil
+	}
+}
+
+// fileKey returns the consistent hashing key of the input file, see FileKey.
+func (p *Partitioner) fileKey() []byte {
+	if len(p.cfg.inputRoot) > 0 {
+		if relPath, err := filepath.Rel(p.cfg.inputRoot, p.inputFile); err == nil {
+			return FileKey(relPath)
+		}
+	}
+
+	return FileKey(p.outputFile)
+}
+
+// runFile copies the input file as is to the shards
+// that get it for the SplitFile split point.
+func (p *Partitioner) runFile(ctx context.Context, input []byte) error {
+	startTime := time.Now()
+
+	key := p.fileKey()
+
+	pl, err := p.cfg.place(nil, &yaml.Node{Kind: yaml.ScalarNode, Value: string(key)}, key)
+	if err != nil {
+		return p.fail("", err)
+	}
+
+	p.stats.add(pl, 1)
+
+	owners := ownerSet(pl.owners)
+
+	if pl.dropped == nil {
+		p.totalItemsBefore = 1
+	}
+
+	var report strings.Builder
+
+	for i, name := range p.cfg.NodeNames() {
+		// Skipping partitioning if thisShardID has set
+		if p.cfg.thisShardID >= 0 && p.cfg.thisShardID != i {
+			continue
+		}
+
+		// Checking if context canceled before writing a shard
+		select {
+		case <-ctx.Done():
+			return p.fail(name, fmt.Errorf("canceled: %w", ctx.Err())) // error somewhere, terminate
+		default: // default is a must to avoid blocking
+		}
+
+		if _, ok := owners[name]; !ok {
+			p.shardItemsCount[name] = 0
+			p.shardBytesCount[name] = 0
+
+			report.WriteString(fmt.Sprintf("Shard %q did not get the file (output file is not created)\n", name))
+
+			continue
+		}
+
+		if err := p.writeFile(name, input); err != nil {
+			return p.fail(name, err)
+		}
+
+		p.shardItemsCount[name] = 1
+		p.shardBytesCount[name] = len(input)
+
+		if pl.broadcast {
+			p.shardBroadcastCount[name] = 1
+		}
+
+		report.WriteString(fmt.Sprintf("Shard %q got the file\n", name))
+	}
+
+	finishTime := time.Since(startTime)
+
+	p.report = fmt.Sprintf("Copying %q of size %d bytes finished in %d ms\n",
+		p.outputFile, len(input), finishTime.Milliseconds()) +
+		fmt.Sprintf("Distributed the file as a single item at path %q into %d shards with RF=%d\n",
+			p.cfg.splitPoint, p.cfg.NodesCount(), p.cfg.replicasCount) +
+		p.stats.report(p.cfg) +
+		report.String()
+
+	return nil
+}
+
+// writeFile writes the input as is to the shard output file.
+func (p *Partitioner) writeFile(shardName string, input []byte) error {
+	outputFile := filepath.Join(p.cfg.workDir, shardName, p.outputFile)
+
+	f, err := createOutputFile(outputFile)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(input); err != nil {
+		f.Close()
+		os.Remove(outputFile)
+
+		return fmt.Errorf("failed to write %q: %w", outputFile, err)
+	}
+
+	return f.Close()
+}
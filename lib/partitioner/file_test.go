@@ -0,0 +1,132 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileKey(t *testing.T) {
+	t.Parallel()
+
+	f := func(outputFile, expected string) {
+		t.Helper()
+
+		require.Equal(t, expected, string(FileKey(outputFile)))
+	}
+
+	f("blackbox.yml", "blackbox.yml")
+	f("/blackbox.yml", "blackbox.yml")
+	f("team-a/blackbox.yml", "team-a/blackbox.yml")
+}
+
+func TestRun_File(t *testing.T) {
+	t.Parallel()
+
+	inputFile, err := filepath.Abs("../../testdata/dir/blackbox-good.yml")
+	require.NoError(t, err)
+
+	input, err := os.ReadFile(inputFile)
+	require.NoError(t, err)
+
+	p, workDir := runInput(t, filepath.Base(inputFile), string(input),
+		WithReplicasCount(2),
+		WithSplitPoint(SplitFile),
+	)
+	require.Equal(t, 1, p.totalItemsBefore)
+
+	owners := p.cfg.Owners(FileKey(p.OutputFile()))
+	require.Len(t, owners, 2)
+
+	for _, name := range shardNames {
+		resultFile := filepath.Join(workDir, name, p.OutputFile())
+
+		if !contains(owners, name) {
+			require.Equal(t, 0, p.ShardItemsCount()[name])
+			require.NoFileExists(t, resultFile)
+
+			continue
+		}
+
+		// The file is copied as is.
+		output, err := os.ReadFile(resultFile)
+		require.NoError(t, err)
+		require.Equal(t, input, output)
+		require.Equal(t, 1, p.ShardItemsCount()[name])
+		require.Equal(t, len(input), p.ShardBytesCount()[name])
+	}
+}
+
+func TestRun_FileUnparsed(t *testing.T) {
+	t.Parallel()
+
+	// The file is not YAML at all.
+	p, workDir := runInput(t, "dashboard.json", `{"title": [`,
+		WithSplitPoint(SplitFile),
+	)
+
+	owners := p.cfg.Owners(FileKey(p.OutputFile()))
+	require.Len(t, owners, 1)
+	require.FileExists(t, filepath.Join(workDir, owners[0], p.OutputFile()))
+}
+
+func TestLocate_FileInputRoot(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	inputFile := filepath.Join(root, "team-a", "blackbox.yml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(inputFile), 0o755))
+	require.NoError(t, os.WriteFile(inputFile, []byte("modules: {}\n"), 0o644))
+
+	f := func(inputRoot, commonPath, expected string) {
+		t.Helper()
+
+		cfg, err := NewConfig(
+			WithConsistentHashing(getConsistentHashing()),
+			WithSplitPoint(SplitFile),
+			WithInputRoot(inputRoot),
+		)
+		require.NoError(t, err)
+
+		p, err := WithConfig(cfg, inputFile, commonPath)
+		require.NoError(t, err)
+
+		locations, err := p.Locate(context.Background(), MatchAll)
+		require.NoError(t, err)
+		require.Len(t, locations, 1)
+		require.Equal(t, expected, string(locations[0].Key))
+	}
+
+	// The key does not depend on the common path of the input files.
+	f(root, root, "team-a/blackbox.yml")
+	f(root, filepath.Join(root, "team-a"), "team-a/blackbox.yml")
+	// Without the input root the key is the output file.
+	f("", filepath.Join(root, "team-a"), "blackbox.yml")
+}
+
+func contains(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+
+	return false
+}
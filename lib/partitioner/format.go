@@ -0,0 +1,137 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The formats of the input files, the result is written in the same format.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// DetectFormat returns the format of the file by its extension,
+// this is FormatYAML for unknown extensions.
+func DetectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatYAML
+	}
+}
+
+// codec decodes the input file into the tree of yaml Nodes,
+// and encodes the partitioned tree back to the same format.
+// The JSON and TOML input is represented with the yaml Nodes as well,
+// so the split point path and the hashing of items are the same
+// for all formats. A codec instance is used for a single input file,
+// since it keeps the layout of the input to reproduce it in the result.
+type codec interface {
+	decode(input []byte) ([]*yaml.Node, error)
+	encode(w io.Writer, docs []*yaml.Node) error
+}
+
+func newCodec(format string, cfg *Config) codec {
+	switch format {
+	case FormatJSON:
+		return &jsonCodec{}
+	case FormatTOML:
+		return &tomlCodec{}
+	default:
+		return &yamlCodec{indent: cfg.resultYamlIndent}
+	}
+}
+
+// yamlCodec handles multi-document YAML streams.
+type yamlCodec struct {
+	indent int
+}
+
+// decode decodes all documents of the input YAML stream.
+func (c *yamlCodec) decode(input []byte) ([]*yaml.Node, error) {
+	return decodeDocuments(input)
+}
+
+// encode writes the documents separated with "---" as in the input YAML stream.
+func (c *yamlCodec) encode(w io.Writer, docs []*yaml.Node) error {
+	yamlEncoder := yaml.NewEncoder(w)
+	yamlEncoder.SetIndent(c.indent)
+
+	defer yamlEncoder.Close()
+
+	for _, doc := range docs {
+		if err := yamlEncoder.Encode(doc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeDocuments decodes all documents of the input YAML stream.
+// The returned nodes are DocumentNodes, so the document comments are kept.
+func decodeDocuments(input []byte) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+
+	dec := yaml.NewDecoder(bytes.NewReader(input))
+
+	for {
+		doc := &yaml.Node{}
+
+		err := dec.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, doc)
+	}
+}
+
+// lineIndex maps the offsets in the input to the line numbers.
+type lineIndex []int
+
+func newLineIndex(input []byte) lineIndex {
+	var idx lineIndex
+
+	for i, b := range input {
+		if b == '\n' {
+			idx = append(idx, i)
+		}
+	}
+
+	return idx
+}
+
+// line returns the line number starting at 1 of the offset.
+func (idx lineIndex) line(offset int) int {
+	return sort.SearchInts(idx, offset) + 1
+}
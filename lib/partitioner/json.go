@@ -0,0 +1,274 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// jsonCodec handles JSON input. The keys of objects are kept in order,
+// numbers are kept as they are written in the input, and the result
+// is indented the same way as the input, or compact if the input is.
+// Concatenated JSON values are decoded as separate documents.
+type jsonCodec struct {
+	// indent is the indentation of the input, empty for the compact input.
+	indent string
+}
+
+func (c *jsonCodec) decode(input []byte) ([]*yaml.Node, error) {
+	c.indent = detectJSONIndent(input)
+
+	d := &jsonDecoder{
+		dec:   json.NewDecoder(bytes.NewReader(input)),
+		input: input,
+		lines: newLineIndex(input),
+	}
+
+	d.dec.UseNumber()
+
+	var docs []*yaml.Node
+
+	for {
+		node, err := d.value()
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{node}, Line: node.Line})
+	}
+}
+
+// detectJSONIndent returns the leading whitespace of the first indented line.
+func detectJSONIndent(input []byte) string {
+	for _, line := range bytes.Split(bytes.TrimSpace(input), []byte("\n")) {
+		content := bytes.TrimLeft(line, " \t")
+		if len(content) > 0 && len(content) < len(line) {
+			return string(line[:len(line)-len(content)])
+		}
+	}
+
+	return ""
+}
+
+type jsonDecoder struct {
+	dec   *json.Decoder
+	input []byte
+	lines lineIndex
+}
+
+// line returns the line of the next token.
+func (d *jsonDecoder) line() int {
+	offset := int(d.dec.InputOffset())
+	for offset < len(d.input) && strings.IndexByte(" \t\r\n,:", d.input[offset]) >= 0 {
+		offset++
+	}
+
+	return d.lines.line(offset)
+}
+
+func (d *jsonDecoder) value() (*yaml.Node, error) {
+	line := d.line()
+
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '{' {
+			return d.object(line)
+		}
+
+		return d.array(line)
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v, Line: line}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(v.String(), ".eE") {
+			tag = "!!float"
+		}
+
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String(), Line: line}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(v), Line: line}, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null", Line: line}, nil
+	default:
+		return nil, fmt.Errorf("unexpected json token %v", tok)
+	}
+}
+
+func (d *jsonDecoder) object(line int) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+
+	for d.dec.More() {
+		key, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+
+		node.Content = append(node.Content, key, value)
+	}
+
+	// The closing delimiter.
+	if _, err := d.dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (d *jsonDecoder) array(line int) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+
+	for d.dec.More() {
+		item, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+
+		node.Content = append(node.Content, item)
+	}
+
+	// The closing delimiter.
+	if _, err := d.dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (c *jsonCodec) encode(w io.Writer, docs []*yaml.Node) error {
+	bw := bufio.NewWriter(w)
+
+	for _, doc := range docs {
+		if err := c.write(bw, doc, 0); err != nil {
+			return err
+		}
+
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
+
+func (c *jsonCodec) write(w *bufio.Writer, node *yaml.Node, depth int) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+
+		return c.write(w, node.Content[0], depth)
+
+	case yaml.MappingNode:
+		return c.writeContainer(w, '{', '}', len(node.Content)/2, depth, func(i int) error {
+			if err := writeJSONString(w, node.Content[2*i].Value); err != nil {
+				return err
+			}
+
+			w.WriteByte(':')
+
+			if len(c.indent) > 0 {
+				w.WriteByte(' ')
+			}
+
+			return c.write(w, node.Content[2*i+1], depth+1)
+		})
+
+	case yaml.SequenceNode:
+		return c.writeContainer(w, '[', ']', len(node.Content), depth, func(i int) error {
+			return c.write(w, node.Content[i], depth+1)
+		})
+
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null", "!!bool", "!!int", "!!float":
+			w.WriteString(node.Value)
+			return nil
+		default:
+			return writeJSONString(w, node.Value)
+		}
+
+	default:
+		return fmt.Errorf("unexpected yaml node kind %v at line %d", node.Kind, node.Line)
+	}
+}
+
+// writeContainer writes an object or an array of n elements, writeElem writes the i-th element.
+func (c *jsonCodec) writeContainer(w *bufio.Writer, open, closing byte, n, depth int,
+	writeElem func(i int) error,
+) error {
+	w.WriteByte(open)
+
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+
+		if len(c.indent) > 0 {
+			w.WriteByte('\n')
+			w.WriteString(strings.Repeat(c.indent, depth+1))
+		}
+
+		if err := writeElem(i); err != nil {
+			return err
+		}
+	}
+
+	if n > 0 && len(c.indent) > 0 {
+		w.WriteByte('\n')
+		w.WriteString(strings.Repeat(c.indent, depth))
+	}
+
+	w.WriteByte(closing)
+
+	return nil
+}
+
+// writeJSONString writes s as a JSON string without escaping HTML characters,
+// e.g. in the Grafana dashboards.
+func writeJSONString(w *bufio.Writer, s string) error {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+
+	w.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONCodec_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	f := func(input, expected string) {
+		t.Helper()
+
+		c := &jsonCodec{}
+
+		docs, err := c.decode([]byte(input))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = c.encode(&buf, docs)
+		require.NoError(t, err)
+		require.Equal(t, expected, buf.String())
+	}
+
+	for _, file := range []string{
+		"../../testdata/json/file_sd.json",
+		// Indented with tabs, HTML characters are not escaped.
+		"../../testdata/json/dashboard.json",
+	} {
+		input, err := os.ReadFile(file)
+		require.NoError(t, err)
+
+		f(string(input), string(input))
+	}
+
+	// The key order and the numbers are kept, the compact input stays compact.
+	f(`{"z":1.50,"a":[1e3,-0,null,true],"m":{"x\/y":"<b>"}}`, `{"z":1.50,"a":[1e3,-0,null,true],"m":{"x/y":"<b>"}}`+"\n")
+	f("{\n    \"a\": [],\n    \"b\": {}\n}\n", "{\n    \"a\": [],\n    \"b\": {}\n}\n")
+	// Concatenated values are separate documents.
+	f("{\"a\":1}\n{\"b\":2}\n", "{\"a\":1}\n{\"b\":2}\n")
+	f("", "")
+}
+
+func TestJSONCodec_Line(t *testing.T) {
+	t.Parallel()
+
+	input, err := os.ReadFile("../../testdata/json/file_sd.json")
+	require.NoError(t, err)
+
+	docs, err := (&jsonCodec{}).decode(input)
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+
+	groups := docs[0].Content[0]
+	require.Len(t, groups.Content, 12)
+	require.Equal(t, 2, groups.Content[0].Line)
+	require.Equal(t, 35, groups.Content[3].Line)
+}
+
+func TestRun_JSON(t *testing.T) {
+	t.Parallel()
+
+	f := func(inputFile, splitPoint string, items func(v any) []any) {
+		t.Helper()
+
+		inputFile, err := filepath.Abs(inputFile)
+		require.NoError(t, err)
+
+		workDir := t.TempDir()
+
+		cfg, err := NewConfig(
+			WithConsistentHashing(getConsistentHashing()),
+			WithReplicasCount(2),
+			WithSplitPoint(splitPoint),
+			WithWorkingDirectory(workDir),
+		)
+		require.NoError(t, err)
+
+		p, err := WithConfig(cfg, inputFile, "")
+		require.NoError(t, err)
+
+		err = p.Run(context.Background())
+		require.NoError(t, err)
+
+		total := 0
+
+		for _, name := range shardNames {
+			count := p.ShardItemsCount()[name]
+			if count == 0 {
+				continue
+			}
+
+			output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+			require.NoError(t, err)
+
+			var v any
+
+			err = json.Unmarshal(output, &v)
+			require.NoError(t, err)
+			require.Len(t, items(v), count)
+
+			total += count
+		}
+
+		require.Equal(t, 2*p.totalItemsBefore, total)
+	}
+
+	f("../../testdata/json/file_sd.json", SplitRoot, func(v any) []any {
+		return v.([]any)
+	})
+
+	f("../../testdata/json/dashboard.json", "panels", func(v any) []any {
+		return v.(map[string]any)["panels"].([]any)
+	})
+}
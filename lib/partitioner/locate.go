@@ -0,0 +1,422 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Location describes where a single item goes.
+type Location struct {
+	// Path is the path to the item in the input YAML,
+	// e.g. "groups.3.rules.5" for an item of a SequenceNode,
+	// or "modules.http_2xx" for an item of a MappingNode.
+	Path string
+	// Owners is the list of shards that get the item.
+	// See Config.Owners for details.
+	Owners []string
+	// Key is the consistent hashing key of the item, i.e. the one set
+	// by DirectiveKey or the item key, see ItemKey.
+	Key []byte
+	// Size is the size of the item key in bytes, see ItemKey.
+	// Unlike Key, it does not depend on DirectiveKey.
+	Size int
+	// Line is the line number of the item in the input YAML.
+	Line int
+	// ReplicasCount is the replication factor of the item placed by consistent
+	// hashing, see WithReplicationOverrides and DirectiveRF, or 0 if the item
+	// is placed otherwise, e.g. by a route.
+	ReplicasCount int
+	// Broadcast is true if the item is kept on every shard, see WithBroadcast.
+	Broadcast bool
+	// Dropped is true if the item is removed from every shard, see WithDrop.
+	Dropped bool
+}
+
+// MatchFunc reports whether the item found at the split point
+// is the one being located. key is nil for items of a SequenceNode.
+type MatchFunc func(key, item *yaml.Node) bool
+
+// MatchAll is a MatchFunc that matches all items.
+func MatchAll(_, _ *yaml.Node) bool { return true }
+
+// MatchValue returns a MatchFunc that matches items containing
+// a scalar equal to value at any depth. If field is not empty,
+// only values of the mapping keys named field are compared.
+// Note: keys of MappingNode items are compared with value as well,
+// e.g. MatchValue("", "http_2xx") matches "modules.http_2xx".
+func MatchValue(field, value string) MatchFunc {
+	var contains func(node *yaml.Node) bool
+
+	contains = func(node *yaml.Node) bool {
+		switch node.Kind { //nolint
+		case yaml.ScalarNode:
+			return len(field) == 0 && node.Value == value
+
+		case yaml.SequenceNode, yaml.DocumentNode:
+			for _, child := range node.Content {
+				if contains(child) {
+					return true
+				}
+			}
+
+		case yaml.MappingNode:
+			for i := 0; i < len(node.Content); i += 2 {
+				k, v := node.Content[i], node.Content[i+1]
+				if len(field) > 0 && k.Value == field && v.Kind == yaml.ScalarNode && v.Value == value {
+					return true
+				}
+
+				if contains(v) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	return func(key, item *yaml.Node) bool {
+		if key != nil && len(field) == 0 && key.Value == value {
+			return true
+		}
+
+		return contains(item)
+	}
+}
+
+// ItemKey returns the consistent hashing key of the yaml item.
+// This is the item encoded back to YAML, so the same item
+// gets the same key regardless of its position in the input file.
+// The comment lines with the directives are left out, see DirectiveAll,
+// so adding or removing a directive does not change the key.
+func ItemKey(item *yaml.Node) ([]byte, error) {
+	if hasDirectiveComments(nil, item) {
+		item = copyNode(item)
+		stripDirectives(nil, item)
+	}
+
+	key, err := yaml.Marshal(item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %v: %w", item, err)
+	}
+
+	return key, nil
+}
+
+// copyNode returns a deep copy of the node.
+// The AliasNodes keep pointing to the original AnchorNodes.
+func copyNode(node *yaml.Node) *yaml.Node {
+	res := *node
+
+	if len(node.Content) > 0 {
+		res.Content = make([]*yaml.Node, len(node.Content))
+
+		for i, child := range node.Content {
+			res.Content[i] = copyNode(child)
+		}
+	}
+
+	return &res
+}
+
+// LocateItem tells which shards get the given YAML item,
+// e.g. a single rule read from stdin.
+// The item must be the exact YAML of a split point item.
+// If mapping is true, i.e. the split point resolves to a MappingNode
+// (see SplitPointKind), the input must be a single
+// key-value pair, e.g. "http_2xx: {prober: http}", and the item is
+// its value, so it is hashed and matched as in the input files.
+func (c *Config) LocateItem(input []byte, mapping bool) (*Location, error) {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(input, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal yaml: %w", err)
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, fmt.Errorf("no yaml item found in the input")
+	}
+
+	var (
+		key  *yaml.Node
+		item = doc.Content[0]
+	)
+
+	if mapping {
+		if item.Kind != yaml.MappingNode || len(item.Content) != 2 {
+			return nil, fmt.Errorf("the item of a MappingNode split point must be a single key-value pair")
+		}
+
+		key, item = item.Content[0], item.Content[1]
+	}
+
+	itemKey, err := ItemKey(item)
+	if err != nil {
+		return nil, err
+	}
+
+	pl, err := c.place(key, item, itemKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Location{
+		Path:          "-",
+		Owners:        pl.owners,
+		Key:           pl.key,
+		Size:          len(itemKey),
+		Line:          item.Line,
+		ReplicasCount: pl.replicasCount,
+		Broadcast:     pl.broadcast,
+		Dropped:       pl.dropped != nil,
+	}, nil
+}
+
+// Locate finds the items at the split point of every document of the input file
+// for which match returns true and tells which shards get them.
+// AliasNode items are skipped, since they follow their AnchorNodes,
+// and so are the merge keys, unless they are expanded, see WithMergeKeys.
+// For the SplitFile split point the file itself is the only item,
+// and match is called with its key as a ScalarNode, see WithInputRoot.
+// For the SplitLines split point match is called with the line
+// as a ScalarNode, and for the SplitCSV split point with the record
+// as a MappingNode of the header columns to the record values.
+func (p *Partitioner) Locate(ctx context.Context, match MatchFunc) ([]*Location, error) {
+	input, err := os.ReadFile(p.inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input file: %w", err)
+	}
+
+	if p.cfg.splitPoint.file {
+		return p.locateFile(match)
+	}
+
+	if p.cfg.splitPoint.text() {
+		return p.locateText(input, match)
+	}
+
+	format := p.cfg.formatOf(p.inputFile)
+
+	docs, err := newCodec(format, p.cfg).decode(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s for %q: %w", format, p.inputFile, err)
+	}
+
+	if p.cfg.splitPoint.documents {
+		return p.locateDocuments(docs, match)
+	}
+
+	var (
+		locations = []*Location{}
+		found     bool
+	)
+
+	locate := func(node *yaml.Node, path []string) error {
+		found = true
+
+		step := 1
+
+		switch node.Kind { //nolint
+		case yaml.SequenceNode:
+		case yaml.MappingNode:
+			// step is 2 because yaml.MappingNode item is a kv pair
+			step = 2
+		case yaml.AliasNode:
+			// The items are located at the AnchorNode.
+			return nil
+		default:
+			return fmt.Errorf("invalid split point path: node at %q is not shardable", p.cfg.splitPoint)
+		}
+
+		content := node.Content
+
+		if node.Kind == yaml.MappingNode && p.cfg.mergeKeys == MergeKeysExpand && hasMergeKeys(node) {
+			var err error
+
+			if content, err = expandMergeKeys(node); err != nil {
+				return err
+			}
+		}
+
+		for i := 0; i < len(content); i += step {
+			var (
+				key, item *yaml.Node
+				pathElem  = strconv.Itoa(i)
+			)
+
+			if node.Kind == yaml.MappingNode {
+				key = content[i]
+				item = content[i+1]
+				pathElem = key.Value
+			} else {
+				item = content[i]
+			}
+
+			// The merge keys are kept in every shard, see MergeKeysKeep.
+			if (key != nil && isMergeKey(key)) || item.Kind == yaml.AliasNode || !match(key, item) {
+				continue
+			}
+
+			itemKey, err := ItemKey(item)
+			if err != nil {
+				return err
+			}
+
+			pl, err := p.cfg.place(key, item, itemKey)
+			if err != nil {
+				return err
+			}
+
+			locations = append(locations, &Location{
+				Path:          strings.Join(path, ".") + "." + pathElem,
+				Owners:        pl.owners,
+				Key:           pl.key,
+				Size:          len(itemKey),
+				Line:          item.Line,
+				ReplicasCount: pl.replicasCount,
+				Broadcast:     pl.broadcast,
+				Dropped:       pl.dropped != nil,
+			})
+		}
+
+		return nil
+	}
+
+	for _, doc := range docs {
+		if err := p.cfg.splitPoint.walk(ctx, doc, locate); err != nil {
+			return nil, fmt.Errorf("failed to locate items in %q: %w", p.inputFile, err)
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("split point path %q not found in %q", p.cfg.splitPoint, p.inputFile)
+	}
+
+	return locations, nil
+}
+
+// SplitPointKind returns the kind of the first node found at the split point
+// of the input files, i.e. yaml.SequenceNode or yaml.MappingNode, see LocateItem.
+// The SplitDocuments, SplitFile, SplitLines and SplitCSV split points
+// have no such node, so yaml.SequenceNode is returned for them.
+func (c *Config) SplitPointKind(ctx context.Context, inputFiles ...string) (yaml.Kind, error) {
+	if c.splitPoint.documents || c.splitPoint.file || c.splitPoint.text() {
+		return yaml.SequenceNode, nil
+	}
+
+	var kind yaml.Kind
+
+	find := func(node *yaml.Node, _ []string) error {
+		if kind == 0 && node.Kind != yaml.AliasNode {
+			kind = node.Kind
+		}
+
+		return nil
+	}
+
+	for _, file := range inputFiles {
+		input, err := os.ReadFile(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read input file: %w", err)
+		}
+
+		format := c.formatOf(file)
+
+		docs, err := newCodec(format, c).decode(input)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unmarshal %s for %q: %w", format, file, err)
+		}
+
+		for _, doc := range docs {
+			if err := c.splitPoint.walk(ctx, doc, find); err != nil {
+				return 0, fmt.Errorf("failed to find split point in %q: %w", file, err)
+			}
+		}
+
+		if kind != 0 {
+			return kind, nil
+		}
+	}
+
+	return 0, fmt.Errorf("split point path %q not found in the input files", c.splitPoint)
+}
+
+// locateDocuments locates the documents for the SplitDocuments split point.
+// The path of a document is its index, e.g. "@documents.2".
+func (p *Partitioner) locateDocuments(docs []*yaml.Node, match MatchFunc) ([]*Location, error) {
+	locations := []*Location{}
+
+	for i, doc := range docs {
+		if len(doc.Content) == 0 || !match(nil, doc.Content[0]) {
+			continue
+		}
+
+		key, err := ItemKey(doc.Content[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate items in %q: %w", p.inputFile, err)
+		}
+
+		pl, err := p.cfg.place(nil, doc.Content[0], key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate items in %q: %w", p.inputFile, err)
+		}
+
+		locations = append(locations, &Location{
+			Path:          SplitDocuments + "." + strconv.Itoa(i),
+			Owners:        pl.owners,
+			Key:           pl.key,
+			Size:          len(key),
+			Line:          doc.Content[0].Line,
+			ReplicasCount: pl.replicasCount,
+			Broadcast:     pl.broadcast,
+			Dropped:       pl.dropped != nil,
+		})
+	}
+
+	return locations, nil
+}
+
+// locateFile locates the input file for the SplitFile split point.
+func (p *Partitioner) locateFile(match MatchFunc) ([]*Location, error) {
+	key := p.fileKey()
+	item := &yaml.Node{Kind: yaml.ScalarNode, Value: string(key)}
+
+	if !match(nil, item) {
+		return []*Location{}, nil
+	}
+
+	pl, err := p.cfg.place(nil, item, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Location{{
+		Path:          SplitFile,
+		Owners:        pl.owners,
+		Key:           pl.key,
+		Size:          len(key),
+		Line:          1,
+		ReplicasCount: pl.replicasCount,
+		Broadcast:     pl.broadcast,
+		Dropped:       pl.dropped != nil,
+	}}, nil
+}
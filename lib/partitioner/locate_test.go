@@ -0,0 +1,287 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLocate_MappingNodePath(t *testing.T) {
+	t.Parallel()
+
+	inputFile, err := filepath.Abs("../../testdata/dir/blackbox-good.yml")
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithReplicasCount(2),
+		WithSplitPoint("modules"),
+	)
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(t, err)
+
+	locations, err := p.Locate(context.Background(), MatchValue("", "http_2xx"))
+	require.NoError(t, err)
+	require.Len(t, locations, 1)
+	require.Equal(t, "modules.http_2xx", locations[0].Path)
+	require.Len(t, locations[0].Owners, 2)
+
+	nodeNames := cfg.consistentHashing.GetN(locations[0].Key, 2)
+	for _, name := range locations[0].Owners {
+		require.Contains(t, nodeNames, name)
+	}
+
+	kind, err := cfg.SplitPointKind(context.Background(), inputFile)
+	require.NoError(t, err)
+	require.Equal(t, yaml.MappingNode, kind)
+
+	// The same item read from stdin must go to the same shards.
+	loc, err := cfg.LocateItem([]byte("http_2xx:\n  prober: http\n  timeout: 5s\n  http:\n"), true)
+	require.NoError(t, err)
+	require.Equal(t, locations[0].Owners, loc.Owners)
+
+	// Only a single key-value pair is an item of a MappingNode split point.
+	_, err = cfg.LocateItem([]byte("prober: http\ntimeout: 5s\nhttp:\n"), true)
+	require.ErrorContains(t, err, "must be a single key-value pair")
+}
+
+func TestLocateItem_MappingNodeRun(t *testing.T) {
+	t.Parallel()
+
+	input, err := os.ReadFile("../../testdata/dir/blackbox-good.yml")
+	require.NoError(t, err)
+
+	// The route matches the key of the item, which is not a part of its value.
+	routes, err := ParseRoutes([]byte("routes:\n  - match: \"@key=tcp_connect\"\n    shards: [gamma]\n"))
+	require.NoError(t, err)
+
+	p, workDir := runInput(t, "blackbox.yml", string(input),
+		WithReplicasCount(2),
+		WithSplitPoint("modules"),
+		WithRoutes(routes...),
+	)
+
+	// owners are the shards each module is written to by the run.
+	owners := make(map[string][]string)
+
+	for _, name := range shardNames {
+		output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		require.NoError(t, err)
+
+		var shard struct {
+			Modules map[string]yaml.Node `yaml:"modules"`
+		}
+
+		require.NoError(t, yaml.Unmarshal(output, &shard))
+
+		for module := range shard.Modules {
+			owners[module] = append(owners[module], name)
+		}
+	}
+
+	var doc yaml.Node
+
+	require.NoError(t, yaml.Unmarshal(input, &doc))
+
+	modules := doc.Content[0].Content[1]
+	require.Len(t, owners, len(modules.Content)/2)
+	require.Equal(t, []string{"gamma"}, owners["tcp_connect"])
+
+	for i := 0; i < len(modules.Content); i += 2 {
+		item, err := yaml.Marshal(&yaml.Node{Kind: yaml.MappingNode, Content: modules.Content[i : i+2]})
+		require.NoError(t, err)
+
+		loc, err := p.cfg.LocateItem(item, true)
+		require.NoError(t, err)
+		require.ElementsMatch(t, owners[modules.Content[i].Value], loc.Owners, modules.Content[i].Value)
+	}
+}
+
+func TestLocate_SequenceNodePath(t *testing.T) {
+	t.Parallel()
+
+	inputFile, err := filepath.Abs("../../testdata/rules/kube-good.yaml")
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithReplicasCount(2),
+		WithSplitPoint("groups.*.rules"),
+	)
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(t, err)
+
+	locations, err := p.Locate(context.Background(), MatchValue("record", "instance:node_num_cpu:sum"))
+	require.NoError(t, err)
+	require.Len(t, locations, 1)
+	require.Equal(t, "groups.0.rules.0", locations[0].Path)
+	require.Equal(t, 4, locations[0].Line)
+
+	// "expr" values are not equal to the record name.
+	locations, err = p.Locate(context.Background(), MatchValue("expr", "instance:node_num_cpu:sum"))
+	require.NoError(t, err)
+	require.Empty(t, locations)
+}
+
+func TestLocate_InvalidSplitPointPath(t *testing.T) {
+	t.Parallel()
+
+	inputFile, err := filepath.Abs("../../testdata/rules/kube-good.yaml")
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithSplitPoint("groups.*.nonexisting"),
+	)
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(t, err)
+
+	_, err = p.Locate(context.Background(), MatchValue("", "x"))
+	require.ErrorContains(t, err, "split point path \"groups.*.nonexisting\" not found")
+}
+
+func TestLocate_MultiDocument(t *testing.T) {
+	t.Parallel()
+
+	inputFile, err := filepath.Abs("../../testdata/multidoc/rules.yml")
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithSplitPoint("groups.*.rules"),
+	)
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(t, err)
+
+	// The items after the first document are located as well.
+	locations, err := p.Locate(context.Background(), MatchValue("alert", "NodeHighLoad"))
+	require.NoError(t, err)
+	require.Len(t, locations, 1)
+	require.Equal(t, "groups.0.rules.2", locations[0].Path)
+	require.Equal(t, 26, locations[0].Line)
+}
+
+func TestLocate_Documents(t *testing.T) {
+	t.Parallel()
+
+	inputFile, err := filepath.Abs("../../testdata/manifests/apps.yaml")
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithSplitPoint(SplitDocuments),
+	)
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(t, err)
+
+	locations, err := p.Locate(context.Background(), MatchValue("name", "payments"))
+	require.NoError(t, err)
+	require.Len(t, locations, 2)
+	require.Equal(t, "@documents.3", locations[0].Path)
+	require.Equal(t, "@documents.4", locations[1].Path)
+	require.Equal(t, 25, locations[0].Line)
+}
+
+func TestLocate_File(t *testing.T) {
+	t.Parallel()
+
+	inputFile, err := filepath.Abs("../../testdata/dir/blackbox-good.yml")
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithReplicasCount(2),
+		WithSplitPoint(SplitFile),
+	)
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, filepath.Dir(filepath.Dir(inputFile)))
+	require.NoError(t, err)
+
+	// The file is matched by its key.
+	locations, err := p.Locate(context.Background(), MatchValue("", "dir/blackbox-good.yml"))
+	require.NoError(t, err)
+	require.Len(t, locations, 1)
+	require.Equal(t, SplitFile, locations[0].Path)
+	require.Equal(t, []byte("dir/blackbox-good.yml"), locations[0].Key)
+	require.Equal(t, cfg.Owners(locations[0].Key), locations[0].Owners)
+
+	locations, err = p.Locate(context.Background(), MatchValue("", "blackbox-good.yml"))
+	require.NoError(t, err)
+	require.Empty(t, locations)
+}
+
+func TestLocate_ItemKeyComments(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithReplicasCount(2),
+		WithSplitPoint("rules"),
+	)
+	require.NoError(t, err)
+
+	f := func(input string) *Location {
+		t.Helper()
+
+		loc, err := cfg.LocateItem([]byte(input), false)
+		require.NoError(t, err)
+
+		return loc
+	}
+
+	expected := f("# The A alert.\nalert: A\nexpr: up == 0 # Fires when down.\n")
+
+	// The key of an item without directives is the item encoded back to YAML
+	// along with its comments, and it must not change between releases.
+	require.Equal(t, "# The A alert.\nalert: A\nexpr: up == 0 # Fires when down.\n", string(expected.Key))
+	require.Equal(t, uint64(0x597640249118c63a), xxhash.Sum64(expected.Key))
+
+	// The directives do not change the key of the item.
+	for _, input := range []string{
+		"# The A alert.\n# yp:rf=2\nalert: A\nexpr: up == 0 # Fires when down.\n",
+		"# The A alert.\nalert: A # yp:rf=2\nexpr: up == 0 # Fires when down.\n",
+	} {
+		loc := f(input)
+		require.Equal(t, expected.Key, loc.Key, input)
+		require.Equal(t, expected.Owners, loc.Owners, input)
+	}
+
+	// The key set by the directive is the one used for consistent hashing.
+	loc := f("# yp:key=payments\nalert: A\n")
+	require.Equal(t, []byte("payments"), loc.Key)
+	require.Equal(t, cfg.ownersN([]byte("payments"), 2), loc.Owners)
+}
@@ -0,0 +1,145 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Merge key policies, see WithMergeKeys.
+const (
+	// MergeKeysKeep keeps the merge keys of a MappingNode split point
+	// in every shard, so the merged keys apply to the items of every shard.
+	MergeKeysKeep = "keep"
+	// MergeKeysExpand replaces the merge keys of a MappingNode split point
+	// with the keys they merge before hashing, so each merged key
+	// is an item on its own.
+	MergeKeysExpand = "expand"
+)
+
+// isMergeKey returns true if the key of a MappingNode is
+// the YAML merge key "<<".
+func isMergeKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge"
+}
+
+// hasMergeKeys returns true if the MappingNode has merge keys.
+func hasMergeKeys(node *yaml.Node) bool {
+	for i := 0; i < len(node.Content); i += 2 {
+		if isMergeKey(node.Content[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expandMergeKeys returns the content of the MappingNode with the merge keys
+// replaced with the key-value pairs they merge, as the YAML decoder does:
+// the explicit keys of the node override the merged ones, and the first
+// of several merged mappings overrides the following ones.
+// The merged key-value pairs are copies without anchors, since the originals
+// are still encoded where they are defined.
+func expandMergeKeys(node *yaml.Node) ([]*yaml.Node, error) {
+	explicit := make(map[string]struct{}, len(node.Content)/2)
+
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i]; !isMergeKey(key) {
+			explicit[key.Value] = struct{}{}
+		}
+	}
+
+	content := make([]*yaml.Node, 0, len(node.Content))
+
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		if !isMergeKey(key) {
+			content = append(content, key, value)
+			continue
+		}
+
+		merged, err := mergedContent(value)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < len(merged); j += 2 {
+			k := merged[j]
+			if _, ok := explicit[k.Value]; ok {
+				continue
+			}
+
+			explicit[k.Value] = struct{}{}
+
+			content = append(content, withoutAnchor(k), withoutAnchor(merged[j+1]))
+		}
+	}
+
+	return content, nil
+}
+
+// mergedContent returns the key-value pairs merged by the value of a merge key,
+// which is either a mapping or a sequence of mappings, usually aliased.
+func mergedContent(value *yaml.Node) ([]*yaml.Node, error) {
+	if value.Kind == yaml.AliasNode && value.Alias != nil {
+		value = value.Alias
+	}
+
+	switch value.Kind { //nolint
+	case yaml.MappingNode:
+		return expandMergeKeys(value)
+
+	case yaml.SequenceNode:
+		var content []*yaml.Node
+
+		for _, v := range value.Content {
+			if v.Kind == yaml.AliasNode && v.Alias != nil {
+				v = v.Alias
+			}
+
+			if v.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("invalid merge key value at line %d: not a map", v.Line)
+			}
+
+			merged, err := expandMergeKeys(v)
+			if err != nil {
+				return nil, err
+			}
+
+			content = append(content, merged...)
+		}
+
+		// The keys of the first mappings win, see expandMergeKeys.
+		return content, nil
+
+	default:
+		return nil, fmt.Errorf("invalid merge key value at line %d: not a map or a list of maps", value.Line)
+	}
+}
+
+// withoutAnchor returns a shallow copy of the node without its anchor.
+func withoutAnchor(node *yaml.Node) *yaml.Node {
+	if len(node.Anchor) == 0 {
+		return node
+	}
+
+	n := *node
+	n.Anchor = ""
+
+	return &n
+}
@@ -0,0 +1,187 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpandMergeKeys(t *testing.T) {
+	t.Parallel()
+
+	f := func(input, expected string) {
+		t.Helper()
+
+		var doc yaml.Node
+
+		err := yaml.Unmarshal([]byte(input), &doc)
+		require.NoError(t, err)
+
+		mapping := doc.Content[0]
+		node := mapping.Content[len(mapping.Content)-1]
+
+		content, err := expandMergeKeys(node)
+		require.NoError(t, err)
+
+		node.Content = content
+
+		output, err := yaml.Marshal(node)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(output))
+	}
+
+	// The explicit keys override the merged ones.
+	f("d: &d {a: 1, b: 2}\nm: {<<: *d, b: 3, c: 4}", "{a: 1, b: 3, c: 4}\n")
+	// The first of the merged maps overrides the following ones.
+	f("d: &d {a: 1, b: 2}\nm: {<<: [{b: 0}, *d], c: 4}", "{b: 0, a: 1, c: 4}\n")
+	// The merge keys of the merged maps are expanded as well.
+	f("d: &d {a: 1}\ne: &e {<<: *d, b: 2}\nm: {<<: *e}", "{a: 1, b: 2}\n")
+	// The anchors of the merged nodes are not copied.
+	f("d: {a: &a 1}\nm: {<<: {a: &b 1}, c: *a}", "{a: 1, c: *a}\n")
+	f("m: {a: 1}", "{a: 1}\n")
+
+	var doc yaml.Node
+
+	err := yaml.Unmarshal([]byte("m: {<<: [1, 2]}"), &doc)
+	require.NoError(t, err)
+
+	_, err = expandMergeKeys(doc.Content[0].Content[1])
+	require.ErrorContains(t, err, "invalid merge key value at line 1: not a map")
+}
+
+func TestRun_MergeKeys(t *testing.T) {
+	t.Parallel()
+
+	input, err := os.ReadFile("../../testdata/anchors/merge.yml")
+	require.NoError(t, err)
+
+	var expected struct {
+		Modules map[string]map[string]any `yaml:"modules"`
+	}
+
+	err = yaml.Unmarshal(input, &expected)
+	require.NoError(t, err)
+	require.Len(t, expected.Modules, 7)
+
+	// The modules merged with the merge key of the split point.
+	merged := map[string]map[string]any{
+		"http_2xx":    {"prober": "http"},
+		"tcp_connect": {"prober": "tcp"},
+		"icmp":        {"prober": "icmp"},
+	}
+
+	f := func(policy string, expectedItems int) {
+		t.Helper()
+
+		workDir := t.TempDir()
+
+		cfg, err := NewConfig(
+			WithConsistentHashing(getConsistentHashing()),
+			WithReplicasCount(2),
+			WithSplitPoint("modules"),
+			WithMergeKeys(policy),
+			WithWorkingDirectory(workDir),
+		)
+		require.NoError(t, err)
+
+		inputFile, err := filepath.Abs("../../testdata/anchors/merge.yml")
+		require.NoError(t, err)
+
+		p, err := WithConfig(cfg, inputFile, "")
+		require.NoError(t, err)
+
+		err = p.Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, expectedItems, p.totalItemsBefore)
+
+		locations, err := p.Locate(context.Background(), MatchAll)
+		require.NoError(t, err)
+		require.Len(t, locations, expectedItems)
+
+		owned := make(map[string]map[string]struct{})
+
+		for _, loc := range locations {
+			for _, name := range loc.Owners {
+				if owned[name] == nil {
+					owned[name] = make(map[string]struct{})
+				}
+
+				owned[name][loc.Path[len("modules."):]] = struct{}{}
+			}
+		}
+
+		total := 0
+
+		for _, name := range shardNames {
+			count := p.ShardItemsCount()[name]
+			require.Len(t, owned[name], count, "Shard: %s", name)
+
+			if count == 0 {
+				continue
+			}
+
+			output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+			require.NoError(t, err)
+
+			var actual struct {
+				Modules map[string]map[string]any `yaml:"modules"`
+			}
+
+			err = yaml.Unmarshal(output, &actual)
+			require.NoError(t, err, "Shard: %s", name)
+
+			for module := range owned[name] {
+				require.Equal(t, expected.Modules[module], actual.Modules[module], "Shard: %s, module: %s", name, module)
+			}
+
+			// The other modules come from the merge key kept in every shard.
+			for module, v := range actual.Modules {
+				if _, ok := owned[name][module]; ok {
+					continue
+				}
+
+				require.Equal(t, MergeKeysKeep, policy, "Shard: %s, module: %s", name, module)
+				require.Equal(t, merged[module], v, "Shard: %s, module: %s", name, module)
+			}
+
+			total += count
+		}
+
+		require.Equal(t, 2*expectedItems, total)
+	}
+
+	// The merged modules are in every shard along with the merge key,
+	// but the merged "http_2xx" is overridden only where the explicit one is.
+	f(MergeKeysKeep, 5)
+	// The merged modules are items on their own.
+	f(MergeKeysExpand, 7)
+}
+
+func TestConfig_MergeKeys(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithSplitPoint("modules"),
+		WithMergeKeys("inline"),
+	)
+	require.ErrorContains(t, err, `unknown merge keys policy: "inline"`)
+}
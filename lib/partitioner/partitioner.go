@@ -19,13 +19,12 @@ package partitioner
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
-
-	"golang.org/x/sync/errgroup"
 )
 
 // New creates a new partitioner for the given inputFile.
@@ -70,13 +69,28 @@ func WithConfig(cfg *Config, inputFile, commonPath string) (*Partitioner, error)
 // Partitioner represents the structure for partitioning
 // a given input file.
 type Partitioner struct {
-	cfg              *Config
-	shardItemsCount  map[string]int
-	inputFile        string
-	outputFile       string
-	report           string
-	totalItemsBefore int
-	mu               sync.Mutex
+	cfg             *Config
+	shardItemsCount map[string]int
+	shardBytesCount map[string]int
+	// shardBroadcastCount is the number of the broadcast items among
+	// the items each shard got, see WithBroadcast.
+	shardBroadcastCount map[string]int
+	inputFile           string
+	outputFile          string
+	report              string
+	totalItemsBefore    int
+	// stats counts the items placed by the routes and the directives.
+	stats *placementStats
+}
+
+// InputFile returns the path to the input file.
+func (p *Partitioner) InputFile() string {
+	return p.inputFile
+}
+
+// OutputFile returns the path to the output file relative to the shard directory.
+func (p *Partitioner) OutputFile() string {
+	return p.outputFile
 }
 
 // Report returns a partitioning report.
@@ -89,30 +103,88 @@ func (p *Partitioner) ShardItemsCount() map[string]int {
 	return p.shardItemsCount
 }
 
+// ShardBytesCount returns the size of the resulting yaml of each shard.
+func (p *Partitioner) ShardBytesCount() map[string]int {
+	return p.shardBytesCount
+}
+
+// ShardBroadcastCount returns how many of the items each shard got are
+// broadcast to every shard, see WithBroadcast. These items are the same
+// for all shards, so they are not counted for the balance of the shards.
+func (p *Partitioner) ShardBroadcastCount() map[string]int {
+	return p.shardBroadcastCount
+}
+
+// RouteItemsCount returns how many items each route of the Config captured.
+func (p *Partitioner) RouteItemsCount() []int {
+	return p.stats.routes
+}
+
+// DirectiveItemsCount returns how many items each directive placed, see DirectiveAll.
+func (p *Partitioner) DirectiveItemsCount() map[string]int {
+	return p.stats.directives
+}
+
+// ReplicasItemsCount returns how many items were placed by consistent hashing
+// with each replication factor, see WithReplicationOverrides.
+func (p *Partitioner) ReplicasItemsCount() map[int]int {
+	return p.stats.replicas
+}
+
+// DroppedItemsCount returns how many items matched the drop selectors, see WithDrop.
+// These items are not counted as the items found at the split point.
+func (p *Partitioner) DroppedItemsCount() int {
+	return len(p.stats.dropped)
+}
+
 // Reset sets the partitioner to its initial state.
 func (p *Partitioner) Reset() {
 	p.totalItemsBefore = 0
+	p.stats = newPlacementStats(p.cfg)
 	p.shardItemsCount = make(map[string]int, p.cfg.NodesCount())
+	p.shardBytesCount = make(map[string]int, p.cfg.NodesCount())
+	p.shardBroadcastCount = make(map[string]int, p.cfg.NodesCount())
 }
 
 // Run performs the partitioning of a given input file
 // accordingly to settings in the Partitioner Config.
+// The returned error is *Error.
 func (p *Partitioner) Run(ctx context.Context) error {
 	p.Reset()
 
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel() // releases resources if slow operation completes before timeout elapses
+	if p.cfg.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.timeout)
+		defer cancel() // releases resources if slow operation completes before timeout elapses
+	}
 
 	input, err := os.ReadFile(p.inputFile)
 	if err != nil {
-		p.cleanupOnError()
-		return fmt.Errorf("failed to read input file: %w", err)
+		return p.fail("", fmt.Errorf("failed to read input file: %w", err))
+	}
+
+	if p.cfg.splitPoint.file {
+		return p.runFile(ctx, input)
+	}
+
+	if p.cfg.splitPoint.text() {
+		return p.runText(ctx, input)
 	}
 
 	startTime := time.Now()
 
-	// any error breaks the entire group but not the other partitioners.
-	g, gCtx := errgroup.WithContext(ctx)
+	// The input is parsed once and the owners of each item
+	// are computed once, then every shard is derived from the same tree.
+	t, err := newTree(ctx, p.cfg, p.outputFile, input)
+	if err != nil {
+		return p.fail("", err)
+	}
+
+	defer t.restore()
+
+	p.totalItemsBefore = t.itemsCountBefore
+	p.stats = t.stats
 
 	shards := make([]*shard, 0, p.cfg.NodesCount())
 
@@ -125,43 +197,16 @@ func (p *Partitioner) Run(ctx context.Context) error {
 		// Checking if context canceled before running a shard
 		select {
 		case <-ctx.Done():
-			p.cleanupOnError()
-			return fmt.Errorf("%s canceled: %w", name, ctx.Err()) // error somewhere, terminate
+			return p.fail(name, fmt.Errorf("canceled: %w", ctx.Err())) // error somewhere, terminate
 		default: // default is a must to avoid blocking
 		}
 
-		shardName := name
-		shard := newShard(shardName, p.cfg)
+		shard := newShard(name, t)
 		shards = append(shards, shard)
 
-		g.Go(func() error {
-			// TODO: handle writing to stdout with bytes.Buffer
-			outputFile := filepath.Join(p.cfg.workDir, shardName, p.outputFile)
-			f, err := createOutputFile(outputFile)
-			if err != nil {
-				return err
-			}
-
-			if err := shard.Run(gCtx, input, f); err != nil {
-				f.Close()
-				os.Remove(outputFile)
-				return err
-			}
-
-			if err := p.setItemsBefore(shard.itemsCountBefore); err != nil {
-				f.Close()
-				os.Remove(outputFile)
-				return err
-			}
-
-			f.Close()
-			return nil
-		})
-	}
-
-	if err := g.Wait(); err != nil {
-		p.cleanupOnError()
-		return fmt.Errorf("failed to partition %q: %w", p.inputFile, err)
+		if err := p.writeShard(shard); err != nil {
+			return p.fail(name, err)
+		}
 	}
 
 	finishTime := time.Since(startTime)
@@ -175,30 +220,28 @@ func (p *Partitioner) Run(ctx context.Context) error {
 	)
 
 	report.WriteString(
-		fmt.Sprintf("Found %d items at path %q, partitioned them into %d shards with RF=%d\n",
-			p.totalItemsBefore, p.cfg.splitPoint, p.cfg.NodesCount(), p.cfg.replicasCount),
+		fmt.Sprintf("Found %d items at path %q%s, partitioned them into %d shards with RF=%d\n",
+			p.totalItemsBefore, p.cfg.splitPoint, perDocument(t.docItemsCountBefore),
+			p.cfg.NodesCount(), p.cfg.replicasCount),
 	)
 
-	for _, shard := range shards {
-		if err := p.setItemsBefore(shard.itemsCountBefore); err != nil {
-			p.cleanupOnError()
-			return err
-		}
+	report.WriteString(p.stats.report(p.cfg))
 
+	for _, shard := range shards {
 		p.shardItemsCount[shard.name] = shard.itemsCountAfter
+		p.shardBytesCount[shard.name] = shard.outputSize
+		p.shardBroadcastCount[shard.name] = shard.broadcastCountAfter
 
 		if shard.itemsCountAfter == 0 {
-			outputFile := filepath.Join(p.cfg.workDir, shard.name, p.outputFile)
-			os.Remove(outputFile)
-
 			report.WriteString(
 				fmt.Sprintf("Shard %q got %d items in resulting yaml (output file is not created)\n",
 					shard.name, shard.itemsCountAfter),
 			)
 		} else {
 			report.WriteString(
-				fmt.Sprintf("Shard %q got %d items in resulting yaml\n",
-					shard.name, shard.itemsCountAfter),
+				fmt.Sprintf("Shard %q got %d items in resulting yaml%s%s%s\n",
+					shard.name, shard.itemsCountAfter, perDocument(shard.docItemsCountAfter),
+					broadcast(shard.broadcastCountAfter), p.pruned(shard)),
 			)
 		}
 	}
@@ -208,21 +251,87 @@ func (p *Partitioner) Run(ctx context.Context) error {
 	return nil
 }
 
-func (p *Partitioner) setItemsBefore(n int) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// broadcast returns the number of the broadcast items among the items
+// of a shard for the report, e.g. ", 3 of them broadcast",
+// or an empty string if there are none, see WithBroadcast.
+func broadcast(count int) string {
+	if count == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(", %d of them broadcast", count)
+}
+
+// pruned returns the numbers of the containers kept and pruned
+// in the shard for the report, e.g. ", kept 3 containers, pruned 2 empty ones",
+// or an empty string if the empty containers are not pruned.
+func (p *Partitioner) pruned(shard *shard) string {
+	if p.cfg.pruneEmpty == PruneEmptyNone {
+		return ""
+	}
+
+	return fmt.Sprintf(", kept %d containers, pruned %d empty ones", shard.keptContainers, shard.prunedContainers)
+}
+
+// perDocument returns the item counts of each document of
+// a multi-document YAML stream for the report,
+// e.g. " (3, 0, 4 per document)", or an empty string for a single document.
+func perDocument(counts []int) string {
+	if len(counts) < 2 {
+		return ""
+	}
+
+	s := make([]string, len(counts))
+	for i, count := range counts {
+		s[i] = strconv.Itoa(count)
+	}
+
+	return fmt.Sprintf(" (%s per document)", strings.Join(s, ", "))
+}
 
-	if p.totalItemsBefore == 0 {
-		p.totalItemsBefore = n
+// writeShard partitions the shared tree for the shard and writes
+// the resulting yaml to the shard output file.
+// The output file is not created if the shard got no items.
+func (p *Partitioner) writeShard(shard *shard) error {
+	shard.Partition()
 
+	if shard.itemsCountAfter == 0 {
 		return nil
 	}
 
-	if p.totalItemsBefore != n {
-		return fmt.Errorf("items consistency error")
+	// TODO: handle writing to stdout with bytes.Buffer
+	outputFile := filepath.Join(p.cfg.workDir, shard.name, p.outputFile)
+
+	f, err := createOutputFile(outputFile)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	output := &countingWriter{w: f}
+
+	if err := shard.Encode(output); err != nil {
+		f.Close()
+		os.Remove(outputFile)
+		return err
+	}
+
+	shard.outputSize = output.n
+
+	return f.Close()
+}
+
+// fail cleans up the output files and returns *Error
+// describing the failure. shard is empty if the failure
+// is not specific to a shard.
+func (p *Partitioner) fail(shard string, err error) error {
+	p.cleanupOnError()
+
+	return &Error{
+		File:       p.inputFile,
+		SplitPoint: p.cfg.splitPoint.String(),
+		Shard:      shard,
+		Err:        err,
+	}
 }
 
 func (p *Partitioner) cleanupOnError() {
@@ -248,3 +357,16 @@ func createOutputFile(path string) (*os.File, error) {
 
 	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644) //nolint
 }
+
+// countingWriter counts the number of bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += n
+
+	return n, err
+}
@@ -22,6 +22,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/asokolov365/YamlPartitioner/lib/hrw"
 	"github.com/cespare/xxhash/v2"
@@ -43,6 +44,57 @@ func getConsistentHashing() ConsistentHashing {
 	return rndv
 }
 
+// newInputPartitioner writes the input to a temporary file with the given name
+// and returns the partitioner of the file over shardNames with the options,
+// along with the temporary working directory it writes the shards to.
+func newInputPartitioner(t *testing.T, name, input string, opts ...Option) (*Partitioner, string) {
+	t.Helper()
+
+	inputFile := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(inputFile, []byte(input), 0o644))
+
+	workDir := t.TempDir()
+
+	cfg, err := NewConfig(append([]Option{
+		WithConsistentHashing(getConsistentHashing()),
+		WithWorkingDirectory(workDir),
+	}, opts...)...)
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(t, err)
+
+	return p, workDir
+}
+
+// runInput partitions the input, see newInputPartitioner.
+func runInput(t *testing.T, name, input string, opts ...Option) (*Partitioner, string) {
+	t.Helper()
+
+	p, workDir := newInputPartitioner(t, name, input, opts...)
+
+	err := p.Run(context.Background())
+	require.NoError(t, err)
+
+	return p, workDir
+}
+
+// parseSelectors parses the selectors, see ParseSelector.
+func parseSelectors(t *testing.T, selectors ...string) []*Selector {
+	t.Helper()
+
+	sels := make([]*Selector, 0, len(selectors))
+
+	for _, s := range selectors {
+		sel, err := ParseSelector(s)
+		require.NoError(t, err)
+
+		sels = append(sels, sel)
+	}
+
+	return sels
+}
+
 func cleanup() error {
 	for _, name := range shardNames {
 		path := filepath.Join(workDir, name)
@@ -100,6 +152,10 @@ func TestRun_SequenceNodePath(t *testing.T) {
 
 		resultFile := filepath.Join(workDir, name, p.outputFile)
 
+		fileInfo, err := os.Stat(resultFile)
+		require.NoError(t, err)
+		require.Equal(t, int(fileInfo.Size()), p.shardBytesCount[name])
+
 		f, err := os.Open(resultFile)
 		require.NoError(t, err)
 
@@ -207,6 +263,33 @@ func TestRun_InputFileNotFound(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestRun_Timeout(t *testing.T) {
+	t.Parallel()
+
+	var err error
+
+	inputFile, err := filepath.Abs("../../testdata/rules/kube-good.yaml")
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithReplicasCount(2),
+		WithResultYamlIndent(2),
+		WithSplitPoint("groups.*.rules"),
+		WithThisShardID(-1),
+		WithWorkingDirectory(workDir),
+		WithTimeout(time.Nanosecond),
+	)
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(t, err)
+
+	err = p.Run(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Empty(t, p.ShardItemsCount())
+}
+
 func TestRun_InvalidCommonPrefix(t *testing.T) {
 	t.Parallel()
 
@@ -228,3 +311,46 @@ func TestRun_InvalidCommonPrefix(t *testing.T) {
 	_, err = WithConfig(cfg, inputFile, "testdata/rules")
 	require.ErrorContains(t, err, "invalid common prefix for ")
 }
+
+func benchmarkRun(b *testing.B, inputFile, splitPoint string, shardsCount int) {
+	b.Helper()
+
+	nodes := make([]string, shardsCount)
+	for i := 0; i < shardsCount; i++ {
+		nodes[i] = fmt.Sprintf("node%d", i)
+	}
+
+	rndv, err := hrw.New(xxhash.Sum64, nodes...)
+	require.NoError(b, err)
+
+	inputFile, err = filepath.Abs(inputFile)
+	require.NoError(b, err)
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(rndv),
+		WithReplicasCount(2),
+		WithSplitPoint(splitPoint),
+		WithWorkingDirectory(b.TempDir()),
+	)
+	require.NoError(b, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := p.Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRun_5Shards(b *testing.B) {
+	benchmarkRun(b, "../../testdata/rules/kube-good.yaml", "groups.*.rules", 5)
+}
+
+func BenchmarkRun_20Shards(b *testing.B) {
+	benchmarkRun(b, "../../testdata/rules/kube-good.yaml", "groups.*.rules", 20)
+}
@@ -0,0 +1,197 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// placement tells which shards get an item and why.
+type placement struct {
+	// owners is the ordered list of shards that get the item, see Config.Owners.
+	owners []string
+	// route is the index of the route that captured the item, or -1.
+	route int
+	// directive is the directive that placed the item, e.g. DirectiveAll, or empty.
+	directive string
+	// broadcast is true if the item is kept on every shard
+	// regardless of consistent hashing, see WithBroadcast.
+	broadcast bool
+	// dropped is the drop selector matching the item, see WithDrop, or nil.
+	dropped *Selector
+	// replicasCount is the replication factor of the item placed
+	// by consistent hashing, see WithReplicationOverrides, or 0.
+	replicasCount int
+	// key is the consistent hashing key of the item,
+	// i.e. the one set by DirectiveKey or the item key.
+	key []byte
+}
+
+// place tells which shards get the item found at the split point.
+// The item matching a drop selector (see WithDrop) goes nowhere.
+// Otherwise, in order of precedence, the item is placed by its directives
+// (see DirectiveAll), by the routes (see WithRoutes), on every shard
+// by the broadcast selectors (see WithBroadcast), or by
+// consistent hashing of hashKey, unless DirectiveKey replaces it, with
+// the replication factor of the item (see WithReplicationOverrides).
+// key is nil for items of a SequenceNode.
+func (c *Config) place(key, item *yaml.Node, hashKey []byte) (*placement, error) {
+	if sel := c.dropSelector(key, item); sel != nil {
+		return &placement{owners: []string{}, route: -1, dropped: sel, key: hashKey}, nil
+	}
+
+	d, err := c.parseDirectives(key, item)
+	if err != nil {
+		return nil, err
+	}
+
+	if d != nil && d.key != nil {
+		hashKey = d.key
+	}
+
+	if d != nil {
+		switch d.placement {
+		case DirectiveAll:
+			return &placement{owners: c.NodeNames(), route: -1, directive: DirectiveAll, broadcast: true, key: hashKey}, nil
+		case DirectiveSkip:
+			return &placement{owners: []string{}, route: -1, directive: DirectiveSkip, key: hashKey}, nil
+		case DirectivePin:
+			return &placement{owners: d.pin, route: -1, directive: DirectivePin, key: hashKey}, nil
+		}
+	}
+
+	for i, r := range c.routes {
+		if r.Selector.Match(key, item) {
+			return &placement{owners: r.Shards, route: i, key: hashKey}, nil
+		}
+	}
+
+	if c.broadcasts(key, item) {
+		return &placement{owners: c.NodeNames(), route: -1, broadcast: true, key: hashKey}, nil
+	}
+
+	replicasCount := c.replicasCountOf(key, item)
+
+	if d != nil && d.rf > 0 {
+		replicasCount = d.rf
+	}
+
+	return &placement{owners: c.ownersN(hashKey, replicasCount), route: -1, replicasCount: replicasCount, key: hashKey}, nil
+}
+
+// ownerSet returns the set of the owners.
+func ownerSet(owners []string) map[string]struct{} {
+	res := make(map[string]struct{}, len(owners))
+	for _, name := range owners {
+		res[name] = struct{}{}
+	}
+
+	return res
+}
+
+// placementStats counts the items placed by the routes, the directives,
+// the broadcast selectors and consistent hashing.
+type placementStats struct {
+	routes     []int
+	directives map[string]int
+	broadcast  int
+	// replicas is the number of the items placed
+	// by consistent hashing with each replication factor.
+	replicas map[int]int
+	// dropped are the items matching the drop selectors.
+	dropped []droppedItem
+}
+
+// droppedItem is an item matching a drop selector.
+type droppedItem struct {
+	line     int
+	selector *Selector
+}
+
+func newPlacementStats(cfg *Config) *placementStats {
+	return &placementStats{
+		routes:     make([]int, len(cfg.routes)),
+		directives: make(map[string]int),
+		replicas:   make(map[int]int),
+	}
+}
+
+// add counts the placement of the item at the line of the input.
+func (s *placementStats) add(pl *placement, line int) {
+	if pl.route >= 0 {
+		s.routes[pl.route]++
+	}
+
+	if len(pl.directive) > 0 {
+		s.directives[pl.directive]++
+	}
+
+	if pl.dropped != nil {
+		s.dropped = append(s.dropped, droppedItem{line: line, selector: pl.dropped})
+	}
+
+	if pl.broadcast {
+		s.broadcast++
+	}
+
+	if pl.replicasCount > 0 {
+		s.replicas[pl.replicasCount]++
+	}
+}
+
+// report returns the dropped items, the number of the items each route captured,
+// the number of the items placed by each directive, the number of
+// the items broadcast to every shard, and the replication factors
+// of the items placed by consistent hashing for the report.
+func (s *placementStats) report(cfg *Config) string {
+	var report strings.Builder
+
+	if len(s.dropped) > 0 {
+		report.WriteString(fmt.Sprintf("Dropped %d items matching the drop selectors\n", len(s.dropped)))
+	}
+
+	for _, d := range s.dropped {
+		report.WriteString(fmt.Sprintf("Dropped the item at line %d matching %q\n", d.line, d.selector))
+	}
+
+	for i, r := range cfg.routes {
+		report.WriteString(fmt.Sprintf("Route %s captured %d items\n", r, s.routes[i]))
+	}
+
+	names := make([]string, 0, len(s.directives))
+	for name := range s.directives {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		report.WriteString(fmt.Sprintf("Directive %q placed %d items\n", name, s.directives[name]))
+	}
+
+	if s.broadcast > 0 {
+		report.WriteString(fmt.Sprintf("Broadcast %d items to every shard\n", s.broadcast))
+	}
+
+	if rfs := replicasReport(s.replicas, cfg.replicasCount); len(rfs) > 0 {
+		report.WriteString(fmt.Sprintf("Replication factors of the hashed items: %s\n", rfs))
+	}
+
+	return report.String()
+}
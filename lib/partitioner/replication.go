@@ -0,0 +1,127 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReplicationOverride sets the replication factor of the items
+// matching the selector instead of the one set with WithReplicasCount.
+type ReplicationOverride struct {
+	Selector      *Selector
+	ReplicasCount int
+}
+
+// String returns the override for the reports, e.g. `"labels.severity=critical" => RF=3`.
+func (o *ReplicationOverride) String() string {
+	return fmt.Sprintf("%q => RF=%d", o.Selector, o.ReplicasCount)
+}
+
+// ParseReplicationOverride parses the override in the form
+// "<selector>=><replication factor>", e.g. "labels.severity=critical=>3".
+// See Selector for the selector syntax.
+func ParseReplicationOverride(s string) (*ReplicationOverride, error) {
+	i := strings.LastIndex(s, "=>")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid replication override %q: missing '=>'", s)
+	}
+
+	sel, err := ParseSelector(s[:i])
+	if err != nil {
+		return nil, fmt.Errorf("invalid replication override %q: %w", s, err)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(s[i+2:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid replication override %q: invalid replication factor", s)
+	}
+
+	return &ReplicationOverride{Selector: sel, ReplicasCount: n}, nil
+}
+
+// WithReplicationOverrides sets the overrides of the replication factor,
+// which are evaluated in order, the first matching override sets
+// the replication factor of the item, e.g. 3 for the critical alerts
+// and 1 for the rest. The "yp:rf" directive takes precedence over them.
+func WithReplicationOverrides(overrides ...*ReplicationOverride) Option {
+	return func(c *Config) error {
+		c.replicationOverrides = overrides
+		return nil
+	}
+}
+
+// ReplicationOverrides returns the overrides of the replication factor,
+// see WithReplicationOverrides.
+func (c *Config) ReplicationOverrides() []*ReplicationOverride {
+	return c.replicationOverrides
+}
+
+// replicasCountOf returns the replication factor of the item.
+// key is nil for items of a SequenceNode.
+func (c *Config) replicasCountOf(key, item *yaml.Node) int {
+	for _, o := range c.replicationOverrides {
+		if o.Selector.Match(key, item) {
+			return o.ReplicasCount
+		}
+	}
+
+	return c.replicasCount
+}
+
+// validateReplicationOverrides makes sure that the replication factor
+// of every override is from 1 to half the number of shards,
+// as for the replication factor of the config.
+func (c *Config) validateReplicationOverrides() error {
+	for _, o := range c.replicationOverrides {
+		if o.ReplicasCount < 1 || o.ReplicasCount > c.maxReplicasCount() {
+			return fmt.Errorf("replication override %s must be from 1 to %d", o, c.maxReplicasCount())
+		}
+	}
+
+	return nil
+}
+
+// replicasReport returns the number of the items placed by consistent hashing
+// with each replication factor for the report, e.g. "RF=1: 120 items, RF=3: 5 items",
+// or an empty string if all of them have the same replication factor as configured.
+func replicasReport(replicas map[int]int, replicasCount int) string {
+	if len(replicas) == 0 {
+		return ""
+	}
+
+	if _, ok := replicas[replicasCount]; ok && len(replicas) == 1 {
+		return ""
+	}
+
+	rfs := make([]int, 0, len(replicas))
+	for rf := range replicas {
+		rfs = append(rfs, rf)
+	}
+
+	sort.Ints(rfs)
+
+	s := make([]string, len(rfs))
+	for i, rf := range rfs {
+		s[i] = fmt.Sprintf("RF=%d: %d items", rf, replicas[rf])
+	}
+
+	return strings.Join(s, ", ")
+}
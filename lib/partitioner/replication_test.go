@@ -0,0 +1,132 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReplicationOverride(t *testing.T) {
+	t.Parallel()
+
+	o, err := ParseReplicationOverride("labels.severity=critical=>3")
+	require.NoError(t, err)
+	require.Equal(t, 3, o.ReplicasCount)
+	require.Equal(t, `"labels.severity=critical" => RF=3`, o.String())
+
+	o, err = ParseReplicationOverride(`expr=~".*=>.*" => 2`)
+	require.NoError(t, err)
+	require.Equal(t, 2, o.ReplicasCount)
+	require.Equal(t, `expr=~".*=>.*"`, o.Selector.String())
+
+	f := func(input, expected string) {
+		t.Helper()
+
+		_, err := ParseReplicationOverride(input)
+		require.ErrorContains(t, err, expected)
+	}
+
+	f("labels.severity=critical", "missing '=>'")
+	f("labels.severity=>3", "invalid selector")
+	f("labels.severity=critical=>three", "invalid replication factor")
+}
+
+func TestConfig_ReplicationOverrides(t *testing.T) {
+	t.Parallel()
+
+	f := func(input, expected string) {
+		t.Helper()
+
+		o, err := ParseReplicationOverride(input)
+		require.NoError(t, err)
+
+		_, err = NewConfig(
+			WithConsistentHashing(getConsistentHashing()),
+			WithSplitPoint("groups.*.rules"),
+			WithReplicationOverrides(o),
+		)
+		if len(expected) == 0 {
+			require.NoError(t, err)
+			return
+		}
+
+		require.ErrorContains(t, err, expected)
+	}
+
+	f("team=a=>2", "")
+	// The limit is the same as for the replication factor of the config.
+	f("team=a=>3", `replication override "team=a" => RF=3 must be from 1 to 2`)
+	f("team=a=>0", `replication override "team=a" => RF=0 must be from 1 to 2`)
+}
+
+func TestRun_ReplicationOverrides(t *testing.T) {
+	t.Parallel()
+
+	input := `rules:
+  - alert: SLOBurn
+    labels:
+      severity: critical
+  - alert: SLOBudget
+    labels:
+      severity: critical
+  # yp:rf=1
+  - alert: SLOLatency
+    labels:
+      severity: critical
+  - record: a
+  - record: b
+`
+
+	critical, err := ParseReplicationOverride("labels.severity=critical=>2")
+	require.NoError(t, err)
+
+	p, _ := runInput(t, "rules.yml", input,
+		WithReplicasCount(1),
+		WithSplitPoint("rules"),
+		WithReplicationOverrides(critical),
+	)
+
+	// The directive takes precedence over the override.
+	require.Equal(t, map[int]int{1: 3, 2: 2}, p.ReplicasItemsCount())
+	require.Contains(t, p.Report(), "Replication factors of the hashed items: RF=1: 3 items, RF=2: 2 items\n")
+
+	total := 0
+	for _, count := range p.ShardItemsCount() {
+		total += count
+	}
+
+	require.Equal(t, 2+2+1+1+1, total)
+
+	locations, err := p.Locate(context.Background(), MatchAll)
+	require.NoError(t, err)
+	require.Len(t, locations, 5)
+
+	for i, expected := range []int{2, 2, 1, 1, 1} {
+		require.Len(t, locations[i].Owners, expected, locations[i].Path)
+	}
+
+	// The replication factors set by the directives are reported without the overrides as well.
+	p, _ = runInput(t, "rules.yml", input,
+		WithReplicasCount(2),
+		WithSplitPoint("rules"),
+	)
+	require.Contains(t, p.Report(), "RF=1: 1 items, RF=2: 4 items")
+
+	// Nothing is reported if all items have the configured replication factor.
+	require.Empty(t, replicasReport(map[int]int{2: 5}, 2))
+}
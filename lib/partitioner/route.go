@@ -0,0 +1,107 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Route forces the items matching the selector onto the given shards
+// regardless of consistent hashing and the replication factor.
+type Route struct {
+	Selector *Selector
+	Shards   []string
+}
+
+// String returns the route for the reports, e.g. `"labels.team=payments" => prom.3`.
+func (r *Route) String() string {
+	return fmt.Sprintf("%q => %s", r.Selector, strings.Join(r.Shards, ", "))
+}
+
+// ParseRoutes parses the routing file in YAML, e.g.
+//
+//	routes:
+//	  - match: labels.team=payments
+//	    shards: [prom.3]
+//	  - match: '@key=~dmz_.*'
+//	    shards: [blackbox.0, blackbox.1]
+//
+// See Selector for the match syntax.
+func ParseRoutes(input []byte) ([]*Route, error) {
+	var file struct {
+		Routes []struct {
+			Match  string   `yaml:"match"`
+			Shards []string `yaml:"shards"`
+		} `yaml:"routes"`
+	}
+
+	if err := yaml.Unmarshal(input, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal routes: %w", err)
+	}
+
+	routes := make([]*Route, 0, len(file.Routes))
+
+	for i, r := range file.Routes {
+		sel, err := ParseSelector(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route #%d: %w", i+1, err)
+		}
+
+		if len(r.Shards) == 0 {
+			return nil, fmt.Errorf("invalid route #%d: no shards", i+1)
+		}
+
+		routes = append(routes, &Route{Selector: sel, Shards: r.Shards})
+	}
+
+	return routes, nil
+}
+
+// WithRoutes sets the routes, which are evaluated in order before
+// consistent hashing, the first matching route places the item.
+// The items not matching any route are placed by consistent hashing.
+// The routes must refer to the shards of the ConsistentHashing.
+func WithRoutes(routes ...*Route) Option {
+	return func(c *Config) error {
+		c.routes = routes
+		return nil
+	}
+}
+
+// Routes returns the routes, see WithRoutes.
+func (c *Config) Routes() []*Route {
+	return c.routes
+}
+
+// validateRoutes makes sure that the routes refer to the known shards.
+func (c *Config) validateRoutes() error {
+	known := make(map[string]struct{}, c.NodesCount())
+	for _, name := range c.NodeNames() {
+		known[name] = struct{}{}
+	}
+
+	for _, r := range c.routes {
+		for _, name := range r.Shards {
+			if _, ok := known[name]; !ok {
+				return fmt.Errorf("route %s refers to unknown shard %q", r, name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRoutes(t *testing.T) {
+	t.Parallel()
+
+	routes, err := ParseRoutes([]byte(`
+routes:
+  - match: labels.team=payments
+    shards: [delta]
+  - match: '@key=~dmz_.*'
+    shards: [alpha, beta]
+`))
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+	require.Equal(t, `"labels.team=payments" => delta`, routes[0].String())
+	require.Equal(t, []string{"alpha", "beta"}, routes[1].Shards)
+
+	f := func(input, expected string) {
+		t.Helper()
+
+		_, err := ParseRoutes([]byte(input))
+		require.ErrorContains(t, err, expected)
+	}
+
+	f("routes: {}", "failed to unmarshal routes")
+	f("routes:\n  - match: team\n    shards: [alpha]", "invalid route #1: invalid selector")
+	f("routes:\n  - match: team=a\n    shards: [alpha]\n  - match: team=b", "invalid route #2: no shards")
+}
+
+func TestConfig_Routes(t *testing.T) {
+	t.Parallel()
+
+	routes, err := ParseRoutes([]byte("routes:\n  - match: team=a\n    shards: [alpha, prom.3]"))
+	require.NoError(t, err)
+
+	_, err = NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithSplitPoint("groups.*.rules"),
+		WithRoutes(routes...),
+	)
+	require.ErrorContains(t, err, `route "team=a" => alpha, prom.3 refers to unknown shard "prom.3"`)
+}
+
+func TestRun_Routes(t *testing.T) {
+	t.Parallel()
+
+	routes, err := ParseRoutes([]byte(`
+routes:
+  - match: record=~by_dc:.*
+    shards: [gamma]
+  - match: record=~.*windows.*
+    shards: [alpha, epsilon]
+  - match: record=none
+    shards: [beta]
+`))
+	require.NoError(t, err)
+
+	workDir := t.TempDir()
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithReplicasCount(2),
+		WithSplitPoint("groups.*.rules"),
+		WithRoutes(routes...),
+		WithWorkingDirectory(workDir),
+	)
+	require.NoError(t, err)
+
+	inputFile, err := filepath.Abs("../../testdata/anchors/case1.yml")
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(t, err)
+
+	err = p.Run(context.Background())
+	require.NoError(t, err)
+
+	// See ../../testdata/anchors/case1.yml: the aliases follow their anchors,
+	// so only the 10 rules are placed, the first matching route wins.
+	require.Equal(t, []int{4, 3, 0}, p.RouteItemsCount())
+	require.Contains(t, p.Report(), `Route "record=~by_dc:.*" => gamma captured 4 items`)
+	require.Contains(t, p.Report(), `Route "record=none" => beta captured 0 items`)
+
+	f := func(selector string, expected []string) {
+		t.Helper()
+
+		sel, err := ParseSelector(selector)
+		require.NoError(t, err)
+
+		locations, err := p.Locate(context.Background(), sel.Match)
+		require.NoError(t, err)
+		require.NotEmpty(t, locations)
+
+		for _, loc := range locations {
+			require.Equal(t, expected, loc.Owners, loc.Path)
+		}
+	}
+
+	f("record=~by_dc:.*", []string{"gamma"})
+	f("record=by_dc_superfarm:windows_cpu_time_total:avg", []string{"alpha", "epsilon"})
+
+	output, err := os.ReadFile(filepath.Join(workDir, "gamma", p.OutputFile()))
+	require.NoError(t, err)
+	require.Contains(t, string(output), "by_dc:node_memory_used:avg")
+	require.Contains(t, string(output), "by_dc:node_cpu_seconds_total:avg")
+}
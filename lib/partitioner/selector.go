@@ -0,0 +1,279 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Selector fields that do not refer to the fields of an item.
+const (
+	// SelectorKey is the key of an item of a MappingNode split point,
+	// e.g. "@key=~http_.*" for the blackbox modules.
+	SelectorKey = "@key"
+	// SelectorValue is the item itself if it is a scalar,
+	// e.g. a line of the SplitLines split point.
+	SelectorValue = "@value"
+)
+
+// Selector matches the items found at the split point by the values
+// of their fields. It is a comma separated list of matchers, all of them
+// must match. A matcher is "<field><op><value>", where field is a dot
+// separated path to a scalar inside the item, e.g. "labels.team",
+// or SelectorKey, or SelectorValue, and op is one of:
+//
+//	=   the field is equal to the value
+//	!=  the field is not equal to the value
+//	=~  the field matches the regular expression
+//	!~  the field does not match the regular expression
+//
+// The regular expressions are anchored, and a missing field is an empty string
+// as for Prometheus label matchers, e.g. "labels.team!=payments" matches
+// the items without the team label. The value may be double-quoted
+// to include commas, e.g. `expr=~".*by \(job, instance\).*",labels.severity=critical`,
+// where \" is a double quote and the other backslashes are kept as is.
+type Selector struct {
+	str      string
+	matchers []*matcher
+}
+
+type matcher struct {
+	path  []string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+// ParseSelector parses the selector, see Selector.
+func ParseSelector(s string) (*Selector, error) {
+	sel := &Selector{str: strings.TrimSpace(s)}
+
+	rest := sel.str
+
+	for len(rest) > 0 {
+		m, tail, err := parseMatcher(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+
+		sel.matchers = append(sel.matchers, m)
+
+		rest = strings.TrimSpace(tail)
+		if len(rest) == 0 {
+			break
+		}
+
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("invalid selector %q: expected ',' before %q", s, rest)
+		}
+
+		rest = strings.TrimSpace(rest[1:])
+		if len(rest) == 0 {
+			return nil, fmt.Errorf("invalid selector %q: trailing ','", s)
+		}
+	}
+
+	if len(sel.matchers) == 0 {
+		return nil, fmt.Errorf("invalid selector %q: no matchers", s)
+	}
+
+	return sel, nil
+}
+
+// parseMatcher parses the first matcher of s and returns the rest of s.
+func parseMatcher(s string) (*matcher, string, error) {
+	i := strings.IndexAny(s, "=!")
+	if i < 0 {
+		return nil, "", fmt.Errorf("no operator in %q", s)
+	}
+
+	field := strings.TrimSpace(s[:i])
+	if len(field) == 0 {
+		return nil, "", fmt.Errorf("no field in %q", s)
+	}
+
+	m := &matcher{path: strings.Split(field, ".")}
+
+	for _, elem := range m.path {
+		if len(elem) == 0 {
+			return nil, "", fmt.Errorf("invalid field %q", field)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(s[i:], "=~"), strings.HasPrefix(s[i:], "!="), strings.HasPrefix(s[i:], "!~"):
+		m.op = s[i : i+2]
+	case s[i] == '=':
+		m.op = "="
+	default:
+		return nil, "", fmt.Errorf("invalid operator in %q", s)
+	}
+
+	rest := strings.TrimLeft(s[i+len(m.op):], " \t")
+
+	if strings.HasPrefix(rest, `"`) {
+		value, n, ok := unquote(rest)
+		if !ok {
+			return nil, "", fmt.Errorf("invalid quoted value in %q: no closing quote", s)
+		}
+
+		m.value = value
+		rest = rest[n:]
+	} else {
+		j := strings.IndexByte(rest, ',')
+		if j < 0 {
+			j = len(rest)
+		}
+
+		m.value = strings.TrimSpace(rest[:j])
+		rest = rest[j:]
+	}
+
+	if m.op == "=~" || m.op == "!~" {
+		re, err := regexp.Compile("^(?:" + m.value + ")$")
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid regexp %q: %w", m.value, err)
+		}
+
+		m.re = re
+	}
+
+	return m, rest, nil
+}
+
+// unquote returns the double-quoted value at the start of s and its length
+// in s. The backslashes are kept as is for the regular expressions,
+// except for the escaped double quotes.
+func unquote(s string) (string, int, bool) {
+	var value strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			return value.String(), i + 1, true
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '"':
+			value.WriteByte('"')
+			i++
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, false
+}
+
+// String returns the selector as it was parsed.
+func (s *Selector) String() string {
+	return s.str
+}
+
+// Match reports whether the item matches all matchers of the selector.
+// key is nil for items of a SequenceNode. This is a MatchFunc.
+func (s *Selector) Match(key, item *yaml.Node) bool {
+	for _, m := range s.matchers {
+		if !m.match(key, item) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *matcher) match(key, item *yaml.Node) bool {
+	value := fieldValue(m.path, key, item)
+
+	switch m.op {
+	case "=":
+		return value == m.value
+	case "!=":
+		return value != m.value
+	case "=~":
+		return m.re.MatchString(value)
+	default: // "!~"
+		return !m.re.MatchString(value)
+	}
+}
+
+// fieldValue returns the value of the scalar at the path inside the item,
+// or an empty string if there is no such scalar.
+func fieldValue(path []string, key, item *yaml.Node) string {
+	if len(path) == 1 {
+		switch path[0] {
+		case SelectorKey:
+			if key != nil {
+				return key.Value
+			}
+
+			return ""
+		case SelectorValue:
+			path = nil
+		}
+	}
+
+	node := item
+
+	for _, elem := range path {
+		node = child(node, elem)
+		if node == nil {
+			return ""
+		}
+	}
+
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	if node.Kind != yaml.ScalarNode {
+		return ""
+	}
+
+	return node.Value
+}
+
+// child returns the value of the key of a MappingNode,
+// or the item at the index of a SequenceNode.
+func child(node *yaml.Node, elem string) *yaml.Node {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	switch node.Kind { //nolint
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == elem && !isMergeKey(node.Content[i]) {
+				return node.Content[i+1]
+			}
+		}
+
+		// The merged keys are overridden by the explicit ones above.
+		if hasMergeKeys(node) {
+			if content, err := expandMergeKeys(node); err == nil {
+				return child(&yaml.Node{Kind: yaml.MappingNode, Content: content}, elem)
+			}
+		}
+
+	case yaml.SequenceNode:
+		if i, err := strconv.Atoi(elem); err == nil && i >= 0 && i < len(node.Content) {
+			return node.Content[i]
+		}
+	}
+
+	return nil
+}
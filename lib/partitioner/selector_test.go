@@ -0,0 +1,99 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseSelector_Invalid(t *testing.T) {
+	t.Parallel()
+
+	f := func(s, expected string) {
+		t.Helper()
+
+		_, err := ParseSelector(s)
+		require.ErrorContains(t, err, expected)
+	}
+
+	f("", "no matchers")
+	f("team", "no operator")
+	f("=payments", "no field")
+	f("labels..team=payments", "invalid field")
+	f("team!payments", "invalid operator")
+	f("team=~(a", "invalid regexp")
+	f(`team="payments`, "no closing quote")
+	f(`team="a" b`, "expected ','")
+	f("team=a,", "trailing ','")
+}
+
+func TestSelector_Match(t *testing.T) {
+	t.Parallel()
+
+	item := `
+alert: KubePodCrashLooping
+expr: rate(kube_pod_container_status_restarts_total[5m]) > 0
+labels:
+  <<: {severity: warning, team: infra}
+  team: payments
+targets: [a, b]
+`
+
+	var doc yaml.Node
+
+	err := yaml.Unmarshal([]byte(item), &doc)
+	require.NoError(t, err)
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Value: "crash_looping"}
+
+	f := func(s string, expected bool) {
+		t.Helper()
+
+		sel, err := ParseSelector(s)
+		require.NoError(t, err)
+		require.Equal(t, expected, sel.Match(key, doc.Content[0]), s)
+	}
+
+	f("labels.team=payments", true)
+	f("labels.team = payments", true)
+	f("labels.team=infra", false)
+	// The merged keys are overridden by the explicit ones.
+	f("labels.severity=warning", true)
+	f("labels.team!=infra", true)
+	f("alert=~Kube.*", true)
+	f("alert=~Kube", false)
+	f("alert!~Kube.*", false)
+	f("targets.1=b", true)
+	f("targets.2=c", false)
+	// The missing fields are empty.
+	f("labels.zone=", true)
+	f("labels.zone!=dmz", true)
+	f("labels=", true)
+	f(`expr=~".*\[5m\].*",labels.team=payments`, true)
+	f(`expr=~".*\[5m\].*",labels.team=infra`, false)
+	f(`expr="rate(kube_pod_container_status_restarts_total[5m]) > 0",alert=~"\"?Kube.*"`, true)
+	f("@key=~crash_.*", true)
+	f("@value=", true)
+
+	scalar := &yaml.Node{Kind: yaml.ScalarNode, Value: "10.0.0.1:9100"}
+
+	sel, err := ParseSelector(`@value=~"10\.0\..*"`)
+	require.NoError(t, err)
+	require.True(t, sel.Match(nil, scalar))
+	require.False(t, sel.Match(nil, doc.Content[0]))
+}
@@ -17,11 +17,11 @@ package partitioner
 import (
 	"bytes"
 	"context"
-	"io"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 // Case where the split point is a yaml.SequenceNode that contains AnchorNodes and AliasNodes.
@@ -53,17 +53,21 @@ func TestShard_YamlWithAnchors1(t *testing.T) {
 	input, err := os.ReadFile("../../testdata/anchors/case1.yml")
 	require.NoError(t, err)
 
+	tr, err := newTree(context.Background(), cfg, "case1.yml", input)
+	require.NoError(t, err)
+
 	for _, name := range shardNames {
 		var buf bytes.Buffer
 
-		shard := newShard(name, cfg)
+		shard := newShard(name, tr)
+		shard.Partition()
 
-		err = shard.Run(context.Background(), input, &buf)
+		err = shard.Encode(&buf)
 		require.NoError(t, err)
 
 		// fmt.Println(buf.String())
 
-		require.Equal(t, expectedTotalItems, shard.itemsCountBefore, "Shard: %s", name)
+		require.Equal(t, expectedTotalItems, tr.itemsCountBefore, "Shard: %s", name)
 		require.Equal(t, expectedItemsAfter[name], shard.itemsCountAfter, "Shard: %s", name)
 	}
 }
@@ -97,17 +101,21 @@ func TestShard_YamlWithAnchors2(t *testing.T) {
 	input, err := os.ReadFile("../../testdata/anchors/case2.yml")
 	require.NoError(t, err)
 
+	tr, err := newTree(context.Background(), cfg, "case2.yml", input)
+	require.NoError(t, err)
+
 	for _, name := range shardNames {
 		var buf bytes.Buffer
 
-		shard := newShard(name, cfg)
+		shard := newShard(name, tr)
+		shard.Partition()
 
-		err = shard.Run(context.Background(), input, &buf)
+		err = shard.Encode(&buf)
 		require.NoError(t, err)
 
 		// fmt.Println(buf.String())
 
-		require.Equal(t, expectedTotalItems, shard.itemsCountBefore, "Shard: %s", name)
+		require.Equal(t, expectedTotalItems, tr.itemsCountBefore, "Shard: %s", name)
 		require.Equal(t, expectedItemsAfter[name], shard.itemsCountAfter, "Shard: %s", name)
 	}
 }
@@ -141,17 +149,21 @@ func TestShard_YamlWithAnchors3(t *testing.T) {
 	input, err := os.ReadFile("../../testdata/anchors/case3.yml")
 	require.NoError(t, err)
 
+	tr, err := newTree(context.Background(), cfg, "case3.yml", input)
+	require.NoError(t, err)
+
 	for _, name := range shardNames {
 		var buf bytes.Buffer
 
-		shard := newShard(name, cfg)
+		shard := newShard(name, tr)
+		shard.Partition()
 
-		err = shard.Run(context.Background(), input, &buf)
+		err = shard.Encode(&buf)
 		require.NoError(t, err)
 
 		// fmt.Println(buf.String())
 
-		require.Equal(t, expectedTotalItems, shard.itemsCountBefore, "Shard: %s", name)
+		require.Equal(t, expectedTotalItems, tr.itemsCountBefore, "Shard: %s", name)
 		require.Equal(t, expectedItemsAfter[name], shard.itemsCountAfter, "Shard: %s", name)
 	}
 }
@@ -185,19 +197,395 @@ func TestShard_YamlWithAnchors4(t *testing.T) {
 	input, err := os.ReadFile("../../testdata/anchors/case4.yml")
 	require.NoError(t, err)
 
+	tr, err := newTree(context.Background(), cfg, "case4.yml", input)
+	require.NoError(t, err)
+
 	for _, name := range shardNames {
 		var buf bytes.Buffer
 
-		shard := newShard(name, cfg)
+		shard := newShard(name, tr)
+		shard.Partition()
 
-		err = shard.Run(context.Background(), input, &buf)
+		err = shard.Encode(&buf)
 		require.NoError(t, err)
 
 		// fmt.Println(buf.String())
 
-		require.Equal(t, expectedTotalItems, shard.itemsCountBefore, "Shard: %s", name)
+		require.Equal(t, expectedTotalItems, tr.itemsCountBefore, "Shard: %s", name)
+		require.Equal(t, expectedItemsAfter[name], shard.itemsCountAfter, "Shard: %s", name)
+	}
+}
+
+// Case where the input is a multi-document YAML stream
+// and one of the documents has no split point.
+func TestShard_MultiDocument(t *testing.T) {
+	t.Parallel()
+
+	var err error
+
+	// See ../../testdata/multidoc/rules.yml: 3 + 0 + 4 rules
+	expectedDocItemsBefore := []int{3, 0, 4}
+	expectedDocItemsAfter := map[string][]int{
+		"alpha":   {1, 0, 2},
+		"beta":    {1, 0, 0},
+		"gamma":   {0, 0, 0},
+		"delta":   {1, 0, 0},
+		"epsilon": {0, 0, 2},
+	}
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithReplicasCount(1),
+		WithResultYamlIndent(2),
+		WithSplitPoint("groups.*.rules"),
+		WithThisShardID(-1),
+		WithWorkingDirectory(workDir),
+	)
+	require.NoError(t, err)
+
+	input, err := os.ReadFile("../../testdata/multidoc/rules.yml")
+	require.NoError(t, err)
+
+	tr, err := newTree(context.Background(), cfg, "rules.yml", input)
+	require.NoError(t, err)
+	require.Equal(t, expectedDocItemsBefore, tr.docItemsCountBefore)
+	require.Equal(t, 7, tr.itemsCountBefore)
+
+	for _, name := range shardNames {
+		var buf bytes.Buffer
+
+		shard := newShard(name, tr)
+		shard.Partition()
+
+		err = shard.Encode(&buf)
+		require.NoError(t, err)
+
+		require.Equal(t, expectedDocItemsAfter[name], shard.docItemsCountAfter, "Shard: %s", name)
+
+		// All documents are written back with their comments.
+		docs, err := decodeDocuments(buf.Bytes())
+		require.NoError(t, err)
+		require.Len(t, docs, 3, "Shard: %s", name)
+		require.Contains(t, buf.String(), "# Recording rules\n", "Shard: %s", name)
+		require.Contains(t, buf.String(),
+			"---\n# This document has no split point, so it goes to every shard as is.\nkind: Metadata\n",
+			"Shard: %s", name)
+		require.Contains(t, buf.String(), "---\n# Alerting rules\n", "Shard: %s", name)
+	}
+}
+
+// Case where each document of a multi-document YAML stream is an item.
+func TestShard_Documents(t *testing.T) {
+	t.Parallel()
+
+	var err error
+
+	// See ../../testdata/manifests/apps.yaml: 6 documents
+	expectedTotalItems := 6
+	expectedItemsAfter := map[string]int{
+		"alpha":   0,
+		"beta":    2,
+		"gamma":   1,
+		"delta":   2,
+		"epsilon": 1,
+	}
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithReplicasCount(1),
+		WithResultYamlIndent(2),
+		WithSplitPoint(SplitDocuments),
+		WithThisShardID(-1),
+		WithWorkingDirectory(workDir),
+	)
+	require.NoError(t, err)
+
+	input, err := os.ReadFile("../../testdata/manifests/apps.yaml")
+	require.NoError(t, err)
+
+	tr, err := newTree(context.Background(), cfg, "apps.yaml", input)
+	require.NoError(t, err)
+	require.Equal(t, expectedTotalItems, tr.itemsCountBefore)
+
+	seen := make(map[string]int)
+
+	for _, name := range shardNames {
+		var buf bytes.Buffer
+
+		shard := newShard(name, tr)
+		shard.Partition()
+
+		err = shard.Encode(&buf)
+		require.NoError(t, err)
 		require.Equal(t, expectedItemsAfter[name], shard.itemsCountAfter, "Shard: %s", name)
+
+		docs, err := decodeDocuments(buf.Bytes())
+		require.NoError(t, err)
+		require.Len(t, docs, shard.itemsCountAfter, "Shard: %s", name)
+
+		for _, doc := range docs {
+			key, err := ItemKey(doc.Content[0])
+			require.NoError(t, err)
+			seen[string(key)]++
+		}
 	}
+
+	// Every document goes to exactly one shard with RF=1 as is.
+	require.Len(t, seen, expectedTotalItems)
+
+	for key, count := range seen {
+		require.Equal(t, 1, count, key)
+	}
+
+	tr.restore()
+	require.Len(t, tr.stream.Content, expectedTotalItems)
+}
+
+// Case where the items refer to the anchors defined deep inside other items,
+// and the anchors are referenced from outside of the split point as well.
+func TestShard_YamlWithAnchors5(t *testing.T) {
+	t.Parallel()
+
+	type rules struct {
+		Groups []struct {
+			Rules []struct {
+				Alert       string            `yaml:"alert"`
+				Labels      map[string]string `yaml:"labels"`
+				Annotations map[string]string `yaml:"annotations"`
+			} `yaml:"rules"`
+		} `yaml:"groups"`
+		InhibitRules []struct {
+			EqualLabels map[string]string `yaml:"equal_labels"`
+		} `yaml:"inhibit_rules"`
+	}
+
+	input, err := os.ReadFile("../../testdata/anchors/case5.yml")
+	require.NoError(t, err)
+
+	var expected rules
+
+	err = yaml.Unmarshal(input, &expected)
+	require.NoError(t, err)
+
+	expectedRules := make(map[string]any)
+
+	for _, g := range expected.Groups {
+		for _, r := range g.Rules {
+			expectedRules[r.Alert] = r
+		}
+	}
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithReplicasCount(1),
+		WithSplitPoint("groups.*.rules"),
+		WithWorkingDirectory(workDir),
+	)
+	require.NoError(t, err)
+
+	tr, err := newTree(context.Background(), cfg, "case5.yml", input)
+	require.NoError(t, err)
+
+	outputs := make(map[string]string, len(shardNames))
+
+	// The shards are derived twice to make sure the tree is restored.
+	for i := 0; i < 2; i++ {
+		total := 0
+
+		for _, name := range shardNames {
+			var buf bytes.Buffer
+
+			shard := newShard(name, tr)
+			shard.Partition()
+
+			err = shard.Encode(&buf)
+			require.NoError(t, err, "Shard: %s", name)
+
+			if i == 0 {
+				outputs[name] = buf.String()
+			} else {
+				require.Equal(t, outputs[name], buf.String(), "Shard: %s", name)
+			}
+
+			var actual rules
+
+			err = yaml.Unmarshal(buf.Bytes(), &actual)
+			require.NoError(t, err, "Shard: %s", name)
+			require.Equal(t, expected.InhibitRules, actual.InhibitRules, "Shard: %s", name)
+
+			for _, g := range actual.Groups {
+				for _, r := range g.Rules {
+					require.Equal(t, expectedRules[r.Alert], r, "Shard: %s", name)
+				}
+
+				total += len(g.Rules)
+			}
+		}
+
+		require.Equal(t, 7, total)
+	}
+
+	// The restored tree is the same as the input.
+	tr.restore()
+
+	docs, err := decodeDocuments(input)
+	require.NoError(t, err)
+
+	var restored, original bytes.Buffer
+
+	err = tr.codec.encode(&restored, tr.docs)
+	require.NoError(t, err)
+
+	err = tr.codec.encode(&original, docs)
+	require.NoError(t, err)
+	require.Equal(t, original.String(), restored.String())
+}
+
+func TestShard_PruneEmpty(t *testing.T) {
+	t.Parallel()
+
+	type group struct {
+		Name        string   `yaml:"name"`
+		Concurrency int      `yaml:"concurrency"`
+		Rules       []string `yaml:"rules"`
+	}
+
+	f := func(policy, input string, check func(owners map[string]bool, groups []group, output string, sh *shard)) {
+		t.Helper()
+
+		cfg, err := NewConfig(
+			WithConsistentHashing(getConsistentHashing()),
+			WithSplitPoint("groups.*.rules"),
+			WithPruneEmpty(policy),
+			WithWorkingDirectory(workDir),
+		)
+		require.NoError(t, err)
+
+		tr, err := newTree(context.Background(), cfg, "rules.yml", []byte(input))
+		require.NoError(t, err)
+
+		for _, name := range shardNames {
+			// owners tells if the shard got each rule.
+			owners := make(map[string]bool)
+
+			for _, rule := range []string{"ra", "rb"} {
+				key, err := ItemKey(&yaml.Node{Kind: yaml.ScalarNode, Value: rule})
+				require.NoError(t, err)
+
+				_, owners[rule] = cfg.consistentHashing.GetN(key, 1)[name]
+			}
+
+			var buf bytes.Buffer
+
+			sh := newShard(name, tr)
+			sh.Partition()
+
+			err = sh.Encode(&buf)
+			require.NoError(t, err, "Shard: %s", name)
+
+			var actual struct {
+				Groups []group `yaml:"groups"`
+			}
+
+			err = yaml.Unmarshal(buf.Bytes(), &actual)
+			require.NoError(t, err, "Shard: %s", name)
+
+			check(owners, actual.Groups, buf.String(), sh)
+		}
+
+		// The restored tree is the same as the input.
+		tr.restore()
+
+		docs, err := decodeDocuments([]byte(input))
+		require.NoError(t, err)
+
+		var restored, original bytes.Buffer
+
+		require.NoError(t, tr.codec.encode(&restored, tr.docs))
+		require.NoError(t, tr.codec.encode(&original, docs))
+		require.Equal(t, original.String(), restored.String())
+	}
+
+	input := `groups:
+  - name: a
+    concurrency: &concurrency 2
+    rules: &rules [ra]
+  - name: b
+    concurrency: *concurrency
+    rules: [rb]
+  - name: c
+    rules: []
+  - name: d
+    rules: *rules
+other: x
+`
+
+	names := func(groups []group) []string {
+		res := []string{}
+		for _, g := range groups {
+			res = append(res, g.Name)
+		}
+
+		return res
+	}
+
+	f(PruneEmptyNone, input, func(_ map[string]bool, groups []group, _ string, sh *shard) {
+		require.Equal(t, []string{"a", "b", "c", "d"}, names(groups))
+		require.Zero(t, sh.prunedContainers)
+	})
+
+	// The originally empty group "c" is kept, the alias follows its anchor.
+	f(PruneEmptyParent, input, func(owners map[string]bool, groups []group, output string, sh *shard) {
+		expected := []string{}
+		if owners["ra"] {
+			expected = append(expected, "a")
+		}
+
+		if owners["rb"] {
+			expected = append(expected, "b")
+		}
+
+		expected = append(expected, "c")
+		if owners["ra"] {
+			expected = append(expected, "d")
+		}
+
+		require.Equal(t, expected, names(groups), output)
+		require.Equal(t, 4-len(expected), sh.prunedContainers)
+		require.Equal(t, len(expected), sh.keptContainers)
+		require.Contains(t, output, "other: x")
+
+		// The anchor is moved from the pruned group "a".
+		for _, g := range groups {
+			if g.Name == "b" {
+				require.Equal(t, 2, g.Concurrency, output)
+			}
+		}
+	})
+
+	input = `groups:
+  - name: a
+    rules: [ra]
+other: x
+`
+
+	f(PruneEmptyParent, input, func(owners map[string]bool, _ []group, output string, _ *shard) {
+		if owners["ra"] {
+			require.Equal(t, input, output)
+		} else {
+			require.Equal(t, "groups: []\nother: x\n", output)
+		}
+	})
+
+	f(PruneEmptyAncestors, input, func(owners map[string]bool, _ []group, output string, sh *shard) {
+		if owners["ra"] {
+			require.Equal(t, input, output)
+			require.Zero(t, sh.prunedContainers)
+		} else {
+			require.Equal(t, "other: x\n", output)
+			require.Equal(t, 2, sh.prunedContainers)
+		}
+	})
 }
 
 func TestShard_SplitPointPathNonShardable(t *testing.T) {
@@ -218,11 +606,7 @@ func TestShard_SplitPointPathNonShardable(t *testing.T) {
 	input, err := os.ReadFile("../../testdata/anchors/case1.yml")
 	require.NoError(t, err)
 
-	var buf bytes.Buffer
-
-	shard := newShard(shardNames[0], cfg)
-
-	err = shard.Run(context.Background(), input, &buf)
+	_, err = newTree(context.Background(), cfg, "case1.yml", input)
 	require.ErrorContains(t, err, "is not shardable")
 }
 
@@ -244,11 +628,7 @@ func TestShard_InvalidSplitPointPath(t *testing.T) {
 	input, err := os.ReadFile("../../testdata/anchors/case1.yml")
 	require.NoError(t, err)
 
-	var buf bytes.Buffer
-
-	shard := newShard(shardNames[0], cfg)
-
-	err = shard.Run(context.Background(), input, &buf)
+	_, err = newTree(context.Background(), cfg, "case1.yml", input)
 	require.ErrorContains(t, err, "split point path \"groups.*.nonexisting\" not found")
 }
 
@@ -270,7 +650,6 @@ func TestShard_NotYaml(t *testing.T) {
 	input, err := os.ReadFile("../../testdata/rfc0822.txt")
 	require.NoError(t, err)
 
-	shard := newShard(shardNames[0], cfg)
-	err = shard.Run(context.Background(), input, io.Discard)
+	_, err = newTree(context.Background(), cfg, "rfc0822.txt", input)
 	require.ErrorContains(t, err, "failed to unmarshal yaml for ")
 }
@@ -15,11 +15,64 @@
 package partitioner
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
+// SplitDocuments is the split point that makes each document
+// of a multi-document YAML stream an item, e.g. to distribute
+// Kubernetes manifests concatenated in a single file.
+const SplitDocuments = "@documents"
+
+// SplitFile is the split point that makes each input file an item.
+// The file is not parsed, it is copied to its shards as is,
+// so this works for the files of any format.
+const SplitFile = "@file"
+
+// SplitLines is the split point that makes each line of a plain text
+// input file an item, e.g. a host of a target list or an object of
+// newline-delimited JSON. Empty lines and lines starting with "#"
+// are not items, the leading ones are kept in every shard as a header.
+const SplitLines = "@lines"
+
+// SplitCSV is the split point that makes each record of a CSV input file
+// an item. The header row is kept in every shard. The record is hashed
+// by the value of the given column, e.g. "@csv:host", or by the whole
+// record if no column is given.
+const SplitCSV = "@csv"
+
+// SplitRoot is the split point of the root node,
+// e.g. the top-level list of a Prometheus file_sd targets file.
+const SplitRoot = "."
+
 func newSplitPoint(s string) (*splitPoint, error) {
+	s = strings.TrimSpace(s)
+
+	switch s {
+	case SplitRoot:
+		return &splitPoint{slice: []string{}, str: SplitRoot}, nil
+	case SplitDocuments:
+		return &splitPoint{slice: []string{SplitDocuments}, str: SplitDocuments, documents: true}, nil
+	case SplitFile:
+		return &splitPoint{slice: []string{SplitFile}, str: SplitFile, file: true}, nil
+	case SplitLines:
+		return &splitPoint{slice: []string{SplitLines}, str: SplitLines, lines: true}, nil
+	case SplitCSV:
+		return &splitPoint{slice: []string{SplitCSV}, str: SplitCSV, csv: true}, nil
+	}
+
+	if column, ok := strings.CutPrefix(s, SplitCSV+":"); ok {
+		if len(column) == 0 {
+			return nil, fmt.Errorf("invalid split point path: %q", s)
+		}
+
+		return &splitPoint{slice: []string{s}, str: s, csv: true, csvKey: column}, nil
+	}
+
 	sp := strings.Split(s, ".")
 	for i := 0; i < len(sp); i++ {
 		elem := strings.TrimSpace(sp[i])
@@ -38,8 +91,23 @@ func newSplitPoint(s string) (*splitPoint, error) {
 type splitPoint struct {
 	str   string
 	slice []string
+	// documents is true for the SplitDocuments split point.
+	documents bool
+	// file is true for the SplitFile split point.
+	file bool
+	// lines is true for the SplitLines split point.
+	lines bool
+	// csv is true for the SplitCSV split point,
+	// csvKey is the name of the key column, it is empty
+	// if the record is hashed as a whole.
+	csv    bool
+	csvKey string
 }
 
+// text reports whether the input file is partitioned as plain text,
+// see SplitLines and SplitCSV.
+func (sp *splitPoint) text() bool { return sp.lines || sp.csv }
+
 // String implements a stringer interface.
 func (sp *splitPoint) String() string { return sp.str }
 
@@ -57,3 +125,69 @@ func (sp *splitPoint) Elem(i int) (string, error) {
 
 	return sp.slice[i], nil
 }
+
+// walkFunc is the type of the function called by walk
+// for each yaml Node found at the split point path.
+// path is the actual path to the node in the yaml Nodes tree,
+// where sequence items are represented by their indexes,
+// e.g. ["groups", "3", "rules"] for the "groups.*.rules" split point.
+// The path slice is reused by walk, so fn must not retain it.
+type walkFunc func(node *yaml.Node, path []string) error
+
+// walk dives into the yaml Nodes tree recursively down to the split point
+// and calls fn for each yaml Node found at the split point path.
+// Note: walk does not follow AliasNodes on the way to the split point.
+func (sp *splitPoint) walk(ctx context.Context, node *yaml.Node, fn walkFunc) error {
+	return sp.descend(ctx, node, make([]string, 0, sp.Len()), fn)
+}
+
+func (sp *splitPoint) descend(ctx context.Context, node *yaml.Node, path []string, fn walkFunc) error {
+	// Checking context before each dive
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default: // default is a must to avoid blocking
+	}
+
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			if err := sp.descend(ctx, child, path, fn); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if len(path) == sp.Len() {
+		return fn(node, path)
+	}
+
+	elem := sp.slice[len(path)]
+
+	switch node.Kind { //nolint
+	case yaml.SequenceNode:
+		if elem != "*" {
+			return nil
+		}
+
+		for i, item := range node.Content {
+			if err := sp.descend(ctx, item, append(path, strconv.Itoa(i)), fn); err != nil {
+				return err
+			}
+		}
+
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Value != elem {
+				continue
+			}
+
+			if err := sp.descend(ctx, node.Content[i+1], append(path, elem), fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
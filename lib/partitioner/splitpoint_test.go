@@ -41,6 +41,16 @@ func Test_SplitPoint(t *testing.T) {
 
 	f("groups.*.rules")
 	f("module")
+	f(SplitDocuments)
+	f(SplitFile)
+	f(SplitLines)
+	f(SplitCSV)
+	f(SplitCSV + ":host")
+
+	sp, err := newSplitPoint(SplitRoot)
+	require.NoError(t, err)
+	require.Equal(t, SplitRoot, sp.String())
+	require.Equal(t, 0, sp.Len())
 }
 
 func Test_SplitPointError(t *testing.T) {
@@ -56,4 +66,5 @@ func Test_SplitPointError(t *testing.T) {
 	f("")
 	f(". . . . . . . .")
 	f("test..test")
+	f(SplitCSV + ":")
 }
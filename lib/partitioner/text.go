@@ -0,0 +1,345 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// textInput represents a plain text input file
+// for the SplitLines and SplitCSV split points.
+type textInput struct {
+	// header is kept in every shard, this is the leading comment lines
+	// for SplitLines, or the header row for SplitCSV.
+	header []byte
+	items  []*textItem
+	// footer is the trailing comment lines kept in every shard.
+	footer []byte
+	// columns are the CSV header columns, nil for SplitLines.
+	columns []string
+}
+
+// textItem represents a single line or CSV record.
+type textItem struct {
+	// raw is the item as is along with the comment lines preceding it.
+	raw []byte
+	key []byte
+	// fields are the CSV record fields, nil for SplitLines.
+	fields []string
+	// line is the line number of the item in the input file.
+	line int
+}
+
+func parseText(sp *splitPoint, input []byte) (*textInput, error) {
+	if sp.csv {
+		return parseCSV(input, sp.csvKey)
+	}
+
+	return parseLines(input), nil
+}
+
+// parseLines makes each non-empty line not starting with "#" an item
+// hashed by the line without the leading and trailing spaces.
+// The comment lines between the items go along with the next item.
+func parseLines(input []byte) *textInput {
+	var (
+		ti       = &textInput{}
+		pending  []byte
+		inHeader = true
+	)
+
+	for n := 1; len(input) > 0; n++ {
+		line := input
+		if i := bytes.IndexByte(input, '\n'); i >= 0 {
+			line = input[:i+1]
+		}
+
+		input = input[len(line):]
+		line = withNewline(line)
+
+		trimmed := bytes.TrimSpace(line)
+
+		if len(trimmed) == 0 || trimmed[0] == '#' {
+			if inHeader {
+				ti.header = append(ti.header, line...)
+			} else {
+				pending = append(pending, line...)
+			}
+
+			continue
+		}
+
+		inHeader = false
+
+		ti.items = append(ti.items, &textItem{
+			raw:  append(pending, line...),
+			key:  trimmed,
+			line: n,
+		})
+
+		pending = nil
+	}
+
+	ti.footer = pending
+
+	return ti
+}
+
+// parseCSV makes each record after the header row an item hashed
+// by the value of the key column, or by the whole record
+// if the key column is empty. The records are kept as is.
+func parseCSV(input []byte, keyColumn string) (*textInput, error) {
+	r := csv.NewReader(bytes.NewReader(input))
+
+	columns, err := r.Read()
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return &textInput{}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	ti := &textInput{
+		header:  withNewline(input[:r.InputOffset()]),
+		columns: columns,
+	}
+
+	keyIdx := -1
+
+	if len(keyColumn) > 0 {
+		for i, column := range columns {
+			if column == keyColumn {
+				keyIdx = i
+				break
+			}
+		}
+
+		if keyIdx < 0 {
+			return nil, fmt.Errorf("key column %q not found in the header %q", keyColumn, strings.Join(columns, ","))
+		}
+	}
+
+	for {
+		offset := r.InputOffset()
+
+		fields, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return ti, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+		raw := withNewline(input[offset:r.InputOffset()])
+
+		it := &textItem{
+			raw:    raw,
+			key:    bytes.TrimSpace(raw),
+			fields: fields,
+			line:   line,
+		}
+
+		if keyIdx >= 0 {
+			it.key = []byte(fields[keyIdx])
+		}
+
+		ti.items = append(ti.items, it)
+	}
+}
+
+// withNewline returns the line ending with a newline,
+// so the lines are not glued together in the output.
+func withNewline(line []byte) []byte {
+	if len(line) == 0 || line[len(line)-1] == '\n' {
+		return line
+	}
+
+	return append(line[:len(line):len(line)], '\n')
+}
+
+// runText partitions the lines or the CSV records of the input file
+// for the SplitLines and SplitCSV split points.
+func (p *Partitioner) runText(ctx context.Context, input []byte) error {
+	startTime := time.Now()
+
+	ti, err := parseText(p.cfg.splitPoint, input)
+	if err != nil {
+		return p.fail("", fmt.Errorf("failed to parse %s: %w", p.outputFile, err))
+	}
+
+	owners := make([]map[string]struct{}, len(ti.items))
+	broadcasts := make([]bool, len(ti.items))
+
+	for i, it := range ti.items {
+		pl, err := p.cfg.place(nil, ti.node(it), it.key)
+		if err != nil {
+			return p.fail("", fmt.Errorf("failed to parse %s: %w", p.outputFile, err))
+		}
+
+		p.stats.add(pl, it.line)
+
+		owners[i] = ownerSet(pl.owners)
+		broadcasts[i] = pl.broadcast
+	}
+
+	p.totalItemsBefore = len(ti.items) - len(p.stats.dropped)
+
+	var report strings.Builder
+
+	for i, name := range p.cfg.NodeNames() {
+		// Skipping partitioning if thisShardID has set
+		if p.cfg.thisShardID >= 0 && p.cfg.thisShardID != i {
+			continue
+		}
+
+		// Checking if context canceled before running a shard
+		select {
+		case <-ctx.Done():
+			return p.fail(name, fmt.Errorf("canceled: %w", ctx.Err())) // error somewhere, terminate
+		default: // default is a must to avoid blocking
+		}
+
+		var (
+			output         bytes.Buffer
+			count          int
+			broadcastCount int
+		)
+
+		output.Write(ti.header)
+
+		for j, it := range ti.items {
+			if _, ok := owners[j][name]; ok {
+				output.Write(it.raw)
+				count++
+
+				if broadcasts[j] {
+					broadcastCount++
+				}
+			}
+		}
+
+		output.Write(ti.footer)
+
+		p.shardItemsCount[name] = count
+		p.shardBroadcastCount[name] = broadcastCount
+
+		if count == 0 {
+			p.shardBytesCount[name] = 0
+
+			report.WriteString(
+				fmt.Sprintf("Shard %q got %d items in resulting file (output file is not created)\n", name, count),
+			)
+
+			continue
+		}
+
+		if err := p.writeFile(name, output.Bytes()); err != nil {
+			return p.fail(name, err)
+		}
+
+		p.shardBytesCount[name] = output.Len()
+
+		report.WriteString(fmt.Sprintf("Shard %q got %d items in resulting file%s\n", name, count, broadcast(broadcastCount)))
+	}
+
+	finishTime := time.Since(startTime)
+
+	p.report = fmt.Sprintf("Partitioning %q of size %d bytes finished in %d ms\n",
+		p.outputFile, len(input), finishTime.Milliseconds()) +
+		fmt.Sprintf("Found %d items at path %q, partitioned them into %d shards with RF=%d\n",
+			p.totalItemsBefore, p.cfg.splitPoint, p.cfg.NodesCount(), p.cfg.replicasCount) +
+		p.stats.report(p.cfg) +
+		report.String()
+
+	return nil
+}
+
+// locateText locates the lines or the CSV records of the input file
+// for the SplitLines and SplitCSV split points.
+// The path of an item is its index, e.g. "@lines.2" or "@csv.2".
+func (p *Partitioner) locateText(input []byte, match MatchFunc) ([]*Location, error) {
+	ti, err := parseText(p.cfg.splitPoint, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate items in %q: %w", p.inputFile, err)
+	}
+
+	prefix := SplitLines
+	if p.cfg.splitPoint.csv {
+		prefix = SplitCSV
+	}
+
+	locations := []*Location{}
+
+	for i, it := range ti.items {
+		if !match(nil, ti.node(it)) {
+			continue
+		}
+
+		pl, err := p.cfg.place(nil, ti.node(it), it.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate items in %q: %w", p.inputFile, err)
+		}
+
+		locations = append(locations, &Location{
+			Path:          prefix + "." + strconv.Itoa(i),
+			Owners:        pl.owners,
+			Key:           pl.key,
+			Size:          len(it.key),
+			Line:          it.line,
+			ReplicasCount: pl.replicasCount,
+			Broadcast:     pl.broadcast,
+			Dropped:       pl.dropped != nil,
+		})
+	}
+
+	return locations, nil
+}
+
+// node returns the item as a yaml Node for MatchFunc,
+// this is a ScalarNode of the line, or a MappingNode
+// of the CSV header columns to the record fields.
+func (ti *textInput) node(it *textItem) *yaml.Node {
+	if ti.columns == nil {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: string(it.key), Line: it.line}
+	}
+
+	node := &yaml.Node{Kind: yaml.MappingNode, Line: it.line}
+
+	for i, column := range ti.columns {
+		if i >= len(it.fields) {
+			break
+		}
+
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: column},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: it.fields[i]},
+		)
+	}
+
+	return node
+}
@@ -0,0 +1,166 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLines(t *testing.T) {
+	t.Parallel()
+
+	input := "# targets\n\nhost-1\n  host-2  \n# backup\nhost-3\n# end"
+
+	ti := parseLines([]byte(input))
+	require.Equal(t, "# targets\n\n", string(ti.header))
+	require.Len(t, ti.items, 3)
+	require.Equal(t, "host-1\n", string(ti.items[0].raw))
+	require.Equal(t, "host-2", string(ti.items[1].key))
+	require.Equal(t, 4, ti.items[1].line)
+	// The comment goes along with the next item.
+	require.Equal(t, "# backup\nhost-3\n", string(ti.items[2].raw))
+	require.Equal(t, "# end\n", string(ti.footer))
+}
+
+func TestParseCSV(t *testing.T) {
+	t.Parallel()
+
+	input := "host,team\n\"web-1\",a\n\"db,1\",b\nweb-2,a"
+
+	ti, err := parseCSV([]byte(input), "host")
+	require.NoError(t, err)
+	require.Equal(t, "host,team\n", string(ti.header))
+	require.Equal(t, []string{"host", "team"}, ti.columns)
+	require.Len(t, ti.items, 3)
+	// The records are kept as is, the key is the value of the key column.
+	require.Equal(t, "\"db,1\",b\n", string(ti.items[1].raw))
+	require.Equal(t, "db,1", string(ti.items[1].key))
+	require.Equal(t, 3, ti.items[1].line)
+	require.Equal(t, "web-2,a\n", string(ti.items[2].raw))
+
+	// The whole record is the key if no key column is given.
+	ti, err = parseCSV([]byte(input), "")
+	require.NoError(t, err)
+	require.Equal(t, "\"web-1\",a", string(ti.items[0].key))
+
+	_, err = parseCSV([]byte(input), "name")
+	require.ErrorContains(t, err, `key column "name" not found`)
+
+	_, err = parseCSV([]byte("host,team\nweb-1\n"), "host")
+	require.ErrorContains(t, err, "wrong number of fields")
+
+	ti, err = parseCSV(nil, "host")
+	require.NoError(t, err)
+	require.Empty(t, ti.items)
+}
+
+func TestRun_Lines(t *testing.T) {
+	t.Parallel()
+
+	var hosts []string
+	for i := 0; i < 50; i++ {
+		hosts = append(hosts, "host-"+strings.Repeat("x", i))
+	}
+
+	input := "# targets\n" + strings.Join(hosts, "\n") + "\n"
+
+	p, workDir := runInput(t, "input", input, WithReplicasCount(2), WithSplitPoint(SplitLines))
+	require.Equal(t, len(hosts), p.totalItemsBefore)
+
+	total := 0
+
+	for _, name := range shardNames {
+		count := p.ShardItemsCount()[name]
+		total += count
+
+		if count == 0 {
+			continue
+		}
+
+		output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+		require.NoError(t, err)
+		require.Equal(t, len(output), p.ShardBytesCount()[name])
+
+		// The header is kept in every shard.
+		lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+		require.Equal(t, "# targets", lines[0])
+		require.Len(t, lines[1:], count)
+
+		for _, line := range lines[1:] {
+			require.Contains(t, p.cfg.Owners([]byte(line)), name)
+		}
+	}
+
+	// Every line goes to 2 shards with RF=2.
+	require.Equal(t, 2*len(hosts), total)
+}
+
+func TestRun_CSV(t *testing.T) {
+	t.Parallel()
+
+	input := "host,team\nweb-1,a\nweb-2,a\ndb-1,b\ndb-2,b\ncache-1,c\n"
+
+	p, workDir := runInput(t, "input", input, WithReplicasCount(2), WithSplitPoint(SplitCSV+":host"))
+	require.Equal(t, 5, p.totalItemsBefore)
+
+	for _, name := range shardNames {
+		if p.ShardItemsCount()[name] == 0 {
+			continue
+		}
+
+		output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+		require.NoError(t, err)
+
+		records := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+		require.Equal(t, "host,team", records[0])
+		require.Len(t, records[1:], p.ShardItemsCount()[name])
+
+		for _, record := range records[1:] {
+			host, _, _ := strings.Cut(record, ",")
+			require.Contains(t, p.cfg.Owners([]byte(host)), name)
+		}
+	}
+}
+
+func TestLocate_CSV(t *testing.T) {
+	t.Parallel()
+
+	inputFile := filepath.Join(t.TempDir(), "hosts.csv")
+	require.NoError(t, os.WriteFile(inputFile, []byte("host,team\nweb-1,a\ndb-1,b\nweb-2,a\n"), 0o644))
+
+	cfg, err := NewConfig(
+		WithConsistentHashing(getConsistentHashing()),
+		WithSplitPoint(SplitCSV+":host"),
+	)
+	require.NoError(t, err)
+
+	p, err := WithConfig(cfg, inputFile, "")
+	require.NoError(t, err)
+
+	locations, err := p.Locate(context.Background(), MatchValue("team", "a"))
+	require.NoError(t, err)
+	require.Len(t, locations, 2)
+	require.Equal(t, "@csv.0", locations[0].Path)
+	require.Equal(t, "@csv.2", locations[1].Path)
+	require.Equal(t, 4, locations[1].Line)
+	require.Equal(t, []byte("web-2"), locations[1].Key)
+	require.Equal(t, cfg.Owners([]byte("web-2")), locations[1].Owners)
+}
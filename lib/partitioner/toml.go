@@ -0,0 +1,799 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// tomlCodec handles TOML input. The tables, the keys and the values
+// are kept as they are written in the input, along with the comments
+// and the indentation. The tables and the arrays of tables are
+// MappingNodes and SequenceNodes, so "servers" is the split point
+// of the [[servers]] array of tables, and "modules" is the split point
+// of the [modules.http_2xx] and [modules.icmp] tables.
+type tomlCodec struct {
+	meta map[*yaml.Node]*tomlMeta
+}
+
+// tomlMeta describes how a node is written in the TOML input.
+type tomlMeta struct {
+	// raw is a scalar value or a key as it is written in the input.
+	raw string
+	// header is the key of a [table] or an [[array of tables]] element.
+	header string
+	// indent is the leading whitespace of the line of a key or a table header.
+	indent string
+	// comments are the comment lines preceding a key or a table header,
+	// an empty string is a blank line between them.
+	comments    []string
+	lineComment string
+	// blank is true if a key or a table header is preceded by a blank line.
+	blank bool
+	// inline is true for the arrays and the inline tables.
+	inline bool
+	// dotted is true for the tables defined by dotted keys, e.g. "a.b = 1".
+	dotted bool
+	// explicit is true for the tables defined by a [table] header.
+	explicit bool
+	// multiline is true for the arrays with the elements on separate lines
+	// prefixed with elemIndent.
+	multiline  bool
+	elemIndent string
+}
+
+// metaOf returns the layout of the node, it is created if missing.
+func (c *tomlCodec) metaOf(node *yaml.Node) *tomlMeta {
+	m, ok := c.meta[node]
+	if !ok {
+		m = &tomlMeta{}
+		c.meta[node] = m
+	}
+
+	return m
+}
+
+// noMeta is the layout of the nodes missing in the input.
+var noMeta = &tomlMeta{}
+
+// layoutOf returns the layout of the node without modifying the codec,
+// so the encoder does not change the state shared by all shards.
+func (c *tomlCodec) layoutOf(node *yaml.Node) *tomlMeta {
+	if m, ok := c.meta[node]; ok {
+		return m
+	}
+
+	return noMeta
+}
+
+func (c *tomlCodec) decode(input []byte) ([]*yaml.Node, error) {
+	c.meta = make(map[*yaml.Node]*tomlMeta)
+
+	if len(bytes.TrimSpace(input)) == 0 {
+		return nil, nil
+	}
+
+	d := &tomlDecoder{
+		codec: c,
+		p:     &unstable.Parser{KeepComments: true},
+		input: input,
+		root:  &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1},
+	}
+
+	if err := d.decode(); err != nil {
+		return nil, err
+	}
+
+	return []*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{d.root}, Line: 1}}, nil
+}
+
+type tomlDecoder struct {
+	codec *tomlCodec
+	p     *unstable.Parser
+	input []byte
+	root  *yaml.Node
+	// current is the table the key-values are added to.
+	current *yaml.Node
+	// comments are the comment lines preceding the next expression.
+	comments []string
+	// commentsOffset is the offset of the first of the comments.
+	commentsOffset int
+}
+
+func (d *tomlDecoder) decode() error {
+	d.p.Reset(d.input)
+	d.current = d.root
+
+	for d.p.NextExpression() {
+		e := d.p.Expression()
+
+		var err error
+
+		switch e.Kind { //nolint
+		case unstable.Comment:
+			d.addComment(e)
+		case unstable.KeyValue:
+			err = d.keyValue(e)
+		case unstable.Table:
+			err = d.table(e)
+		case unstable.ArrayTable:
+			err = d.arrayTable(e)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := d.p.Error(); err != nil {
+		var perr *unstable.ParserError
+		if errors.As(err, &perr) && len(perr.Highlight) > 0 {
+			// Highlight is a subslice of the input.
+			return fmt.Errorf("line %d: %w", d.line(cap(d.input)-cap(perr.Highlight)), err)
+		}
+
+		return err
+	}
+
+	// The trailing comments.
+	if len(d.comments) > 0 {
+		m := d.codec.metaOf(d.root)
+		m.comments = d.comments
+		m.blank = blankBefore(d.input, d.commentsOffset)
+	}
+
+	return nil
+}
+
+func (d *tomlDecoder) addComment(e *unstable.Node) {
+	offset := int(e.Raw.Offset)
+
+	if len(d.comments) == 0 {
+		d.commentsOffset = offset
+	} else if blankBefore(d.input, offset) {
+		d.comments = append(d.comments, "")
+	}
+
+	d.comments = append(d.comments, lineIndent(d.input, offset)+strings.TrimRight(string(e.Data), "\r"))
+}
+
+// describe sets the layout of the key or the table header at the offset.
+func (d *tomlDecoder) describe(m *tomlMeta, e *unstable.Node, offset int) {
+	m.indent = lineIndent(d.input, offset)
+	m.comments = d.comments
+	m.blank = blankBefore(d.input, offset)
+
+	if len(d.comments) > 0 {
+		m.blank = blankBefore(d.input, d.commentsOffset)
+	}
+
+	if next := e.Next(); next != nil && next.Kind == unstable.Comment {
+		m.lineComment = strings.TrimRight(string(next.Data), "\r")
+	}
+
+	d.comments = nil
+}
+
+type tomlKey struct {
+	name string
+	raw  string
+	// offset is the offset of the key in the input.
+	offset int
+}
+
+func (d *tomlDecoder) keyParts(e *unstable.Node) []tomlKey {
+	var parts []tomlKey
+
+	it := e.Key()
+	for it.Next() {
+		n := it.Node()
+		parts = append(parts, tomlKey{
+			name:   string(n.Data),
+			raw:    string(d.p.Raw(n.Raw)),
+			offset: int(n.Raw.Offset),
+		})
+	}
+
+	return parts
+}
+
+// headerOf returns the key of a table header as it is written in the input.
+func (d *tomlDecoder) headerOf(parts []tomlKey) string {
+	last := parts[len(parts)-1]
+	return string(d.input[parts[0].offset : last.offset+len(last.raw)])
+}
+
+func (d *tomlDecoder) line(offset int) int {
+	return bytes.Count(d.input[:offset], []byte("\n")) + 1
+}
+
+func (d *tomlDecoder) keyValue(e *unstable.Node) error {
+	parts := d.keyParts(e)
+	line := d.line(parts[0].offset)
+
+	table, err := d.dotted(d.current, parts[:len(parts)-1], line)
+	if err != nil {
+		return err
+	}
+
+	last := parts[len(parts)-1]
+	if lookup(table, last.name) != nil {
+		return fmt.Errorf("line %d: duplicate key %q", line, last.name)
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last.name, Line: line}
+
+	keyMeta := d.codec.metaOf(key)
+	keyMeta.raw = last.raw
+	d.describe(keyMeta, e, parts[0].offset)
+
+	value, err := d.value(e.Value(), line)
+	if err != nil {
+		return err
+	}
+
+	table.Content = append(table.Content, key, value)
+
+	return nil
+}
+
+// dotted returns the table defined by the dotted key parts in the table.
+func (d *tomlDecoder) dotted(table *yaml.Node, parts []tomlKey, line int) (*yaml.Node, error) {
+	for _, part := range parts {
+		child := lookup(table, part.name)
+
+		if child == nil {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: part.name, Line: line}
+			d.codec.metaOf(key).raw = part.raw
+
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+			d.codec.metaOf(child).dotted = true
+
+			table.Content = append(table.Content, key, child)
+		}
+
+		if child.Kind != yaml.MappingNode || d.codec.metaOf(child).inline {
+			return nil, fmt.Errorf("line %d: key %q is not a table", line, part.name)
+		}
+
+		table = child
+	}
+
+	return table, nil
+}
+
+// walk returns the table defined by the table header key parts,
+// the missing tables are created, the last element of an array
+// of tables is taken as in the TOML spec.
+func (d *tomlDecoder) walk(parts []tomlKey, line int) (*yaml.Node, error) {
+	table := d.root
+
+	for _, part := range parts {
+		child := lookup(table, part.name)
+
+		if child == nil {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: part.name, Line: line}
+			d.codec.metaOf(key).raw = part.raw
+
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+			table.Content = append(table.Content, key, child)
+		}
+
+		if child.Kind == yaml.SequenceNode && !d.codec.metaOf(child).inline && len(child.Content) > 0 {
+			child = child.Content[len(child.Content)-1]
+		}
+
+		if child.Kind != yaml.MappingNode || d.codec.metaOf(child).inline {
+			return nil, fmt.Errorf("line %d: key %q is not a table", line, part.name)
+		}
+
+		table = child
+	}
+
+	return table, nil
+}
+
+func (d *tomlDecoder) table(e *unstable.Node) error {
+	parts := d.keyParts(e)
+	line := d.line(parts[0].offset)
+
+	table, err := d.walk(parts, line)
+	if err != nil {
+		return err
+	}
+
+	m := d.codec.metaOf(table)
+	if m.explicit {
+		return fmt.Errorf("line %d: table %q is already defined", line, d.headerOf(parts))
+	}
+
+	m.explicit = true
+	m.header = d.headerOf(parts)
+	d.describe(m, e, parts[0].offset)
+
+	table.Line = line
+	d.current = table
+
+	return nil
+}
+
+func (d *tomlDecoder) arrayTable(e *unstable.Node) error {
+	parts := d.keyParts(e)
+	line := d.line(parts[0].offset)
+
+	parent, err := d.walk(parts[:len(parts)-1], line)
+	if err != nil {
+		return err
+	}
+
+	last := parts[len(parts)-1]
+	array := lookup(parent, last.name)
+
+	if array == nil {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last.name, Line: line}
+		d.codec.metaOf(key).raw = last.raw
+
+		array = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+		parent.Content = append(parent.Content, key, array)
+	}
+
+	if array.Kind != yaml.SequenceNode || d.codec.metaOf(array).inline {
+		return fmt.Errorf("line %d: key %q is not an array of tables", line, last.name)
+	}
+
+	table := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+
+	m := d.codec.metaOf(table)
+	m.header = d.headerOf(parts)
+	d.describe(m, e, parts[0].offset)
+
+	array.Content = append(array.Content, table)
+	d.current = table
+
+	return nil
+}
+
+func (d *tomlDecoder) value(n *unstable.Node, line int) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Line: line}
+
+	switch n.Kind { //nolint
+	case unstable.String:
+		node.Tag = "!!str"
+		node.Value = string(n.Data)
+		d.codec.metaOf(node).raw = string(d.p.Raw(n.Raw))
+
+		return node, nil
+
+	case unstable.Bool:
+		node.Tag = "!!bool"
+	case unstable.Integer:
+		node.Tag = "!!int"
+	case unstable.Float:
+		node.Tag = "!!float"
+	case unstable.LocalDate, unstable.LocalTime, unstable.LocalDateTime, unstable.DateTime:
+		node.Tag = "!!timestamp"
+
+	case unstable.Array:
+		return d.array(n, line)
+
+	case unstable.InlineTable:
+		node.Kind = yaml.MappingNode
+		node.Tag = "!!map"
+		d.codec.metaOf(node).inline = true
+
+		it := n.Children()
+		for it.Next() {
+			kv := it.Node()
+			parts := d.keyParts(kv)
+
+			table, err := d.dotted(node, parts[:len(parts)-1], line)
+			if err != nil {
+				return nil, err
+			}
+
+			last := parts[len(parts)-1]
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last.name, Line: line}
+			d.codec.metaOf(key).raw = last.raw
+
+			value, err := d.value(kv.Value(), line)
+			if err != nil {
+				return nil, err
+			}
+
+			table.Content = append(table.Content, key, value)
+		}
+
+		return node, nil
+
+	default:
+		return nil, fmt.Errorf("line %d: unexpected toml value %s", line, n.Kind)
+	}
+
+	// The Data of the other scalars is the raw value.
+	node.Value = string(n.Data)
+	d.codec.metaOf(node).raw = node.Value
+
+	return node, nil
+}
+
+func (d *tomlDecoder) array(n *unstable.Node, line int) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+
+	m := d.codec.metaOf(node)
+	m.inline = true
+
+	it := n.Children()
+	for it.Next() {
+		child := it.Node()
+		if child.Kind == unstable.Comment {
+			continue
+		}
+
+		elemLine := line
+
+		if offset, ok := d.offsetOf(child); ok {
+			elemLine = d.line(offset)
+
+			if len(node.Content) == 0 && elemLine != line {
+				m.multiline = true
+				m.elemIndent = lineIndent(d.input, offset)
+			}
+		}
+
+		elem, err := d.value(child, elemLine)
+		if err != nil {
+			return nil, err
+		}
+
+		node.Content = append(node.Content, elem)
+	}
+
+	return node, nil
+}
+
+// offsetOf returns the offset of the value in the input if it is known.
+func (d *tomlDecoder) offsetOf(n *unstable.Node) (int, bool) {
+	if n.Raw.Length > 0 {
+		return int(n.Raw.Offset), true
+	}
+
+	if n.Kind == unstable.Array {
+		it := n.Children()
+		for it.Next() {
+			if it.Node().Kind != unstable.Comment {
+				return d.offsetOf(it.Node())
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// lookup returns the value of the key in the MappingNode, or nil.
+func lookup(table *yaml.Node, key string) *yaml.Node {
+	for i := 0; i < len(table.Content); i += 2 {
+		if table.Content[i].Value == key {
+			return table.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// lineIndent returns the leading whitespace of the line at the offset.
+func lineIndent(input []byte, offset int) string {
+	start := bytes.LastIndexByte(input[:offset], '\n') + 1
+	line := input[start:offset]
+
+	return string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+}
+
+// blankBefore reports whether the line preceding the line at the offset is blank.
+func blankBefore(input []byte, offset int) bool {
+	start := bytes.LastIndexByte(input[:offset], '\n')
+	if start < 0 {
+		return false
+	}
+
+	prevStart := bytes.LastIndexByte(input[:start], '\n') + 1
+
+	return len(bytes.TrimSpace(input[prevStart:start])) == 0
+}
+
+func (c *tomlCodec) encode(w io.Writer, docs []*yaml.Node) error {
+	e := &tomlEncoder{codec: c, w: bufio.NewWriter(w)}
+
+	for _, doc := range docs {
+		if len(doc.Content) == 0 {
+			continue
+		}
+
+		root := doc.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return fmt.Errorf("unexpected yaml node kind %v at line %d", root.Kind, root.Line)
+		}
+
+		e.table(root)
+
+		m := c.layoutOf(root)
+		e.comments(m.comments, m.blank)
+	}
+
+	return e.w.Flush()
+}
+
+type tomlEncoder struct {
+	codec *tomlCodec
+	w     *bufio.Writer
+	// started is true once anything is written.
+	started bool
+}
+
+// isTable reports whether the node is written as a [table].
+func (e *tomlEncoder) isTable(node *yaml.Node) bool {
+	m := e.codec.layoutOf(node)
+	return node.Kind == yaml.MappingNode && !m.inline && !m.dotted
+}
+
+// isArrayOfTables reports whether the node is written as [[array of tables]].
+// The empty one is written as an empty array.
+func (e *tomlEncoder) isArrayOfTables(node *yaml.Node) bool {
+	return node.Kind == yaml.SequenceNode && !e.codec.layoutOf(node).inline && len(node.Content) > 0
+}
+
+// table writes the key-values of the table followed by its sub-tables.
+func (e *tomlEncoder) table(table *yaml.Node) {
+	for i := 0; i < len(table.Content); i += 2 {
+		key, value := table.Content[i], table.Content[i+1]
+		if !e.isTable(value) && !e.isArrayOfTables(value) {
+			e.keyValue("", key, value)
+		}
+	}
+
+	for i := 0; i < len(table.Content); i += 2 {
+		value := table.Content[i+1]
+
+		switch {
+		case e.isTable(value):
+			m := e.codec.layoutOf(value)
+
+			// The implicit tables without key-values are defined by their sub-tables.
+			if m.explicit || !e.hasKeyValues(value) && !e.hasTables(value) {
+				e.header(m, "["+e.headerOf(table, i)+"]")
+			}
+
+			e.table(value)
+
+		case e.isArrayOfTables(value):
+			for _, elem := range value.Content {
+				e.header(e.codec.layoutOf(elem), "[["+e.headerOf(table, i)+"]]")
+				e.table(elem)
+			}
+		}
+	}
+}
+
+// headerOf returns the header key of the i-th key of the table.
+func (e *tomlEncoder) headerOf(table *yaml.Node, i int) string {
+	value := table.Content[i+1]
+
+	if value.Kind == yaml.SequenceNode && len(value.Content) > 0 {
+		value = value.Content[0]
+	}
+
+	if m := e.codec.layoutOf(value); len(m.header) > 0 {
+		return m.header
+	}
+
+	return e.keyOf(table.Content[i])
+}
+
+func (e *tomlEncoder) hasKeyValues(table *yaml.Node) bool {
+	for i := 1; i < len(table.Content); i += 2 {
+		if !e.isTable(table.Content[i]) && !e.isArrayOfTables(table.Content[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *tomlEncoder) hasTables(table *yaml.Node) bool {
+	for i := 1; i < len(table.Content); i += 2 {
+		if e.isTable(table.Content[i]) || e.isArrayOfTables(table.Content[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *tomlEncoder) header(m *tomlMeta, header string) {
+	e.comments(m.comments, m.blank)
+
+	if len(m.comments) == 0 && m.blank && e.started {
+		e.w.WriteByte('\n')
+	}
+
+	e.line(m.indent + header + e.lineComment(m))
+}
+
+func (e *tomlEncoder) keyValue(prefix string, key, value *yaml.Node) {
+	if e.codec.layoutOf(value).dotted {
+		for i := 0; i < len(value.Content); i += 2 {
+			e.keyValue(prefix+e.keyOf(key)+".", value.Content[i], value.Content[i+1])
+		}
+
+		return
+	}
+
+	m := e.codec.layoutOf(key)
+
+	e.comments(m.comments, m.blank)
+
+	if len(m.comments) == 0 && m.blank && e.started {
+		e.w.WriteByte('\n')
+	}
+
+	e.line(m.indent + prefix + e.keyOf(key) + " = " + e.value(value, m.indent) + e.lineComment(m))
+}
+
+func (e *tomlEncoder) comments(comments []string, blank bool) {
+	if len(comments) == 0 {
+		return
+	}
+
+	if blank && e.started {
+		e.w.WriteByte('\n')
+	}
+
+	for _, comment := range comments {
+		e.line(comment)
+	}
+}
+
+func (e *tomlEncoder) lineComment(m *tomlMeta) string {
+	if len(m.lineComment) == 0 {
+		return ""
+	}
+
+	return " " + m.lineComment
+}
+
+func (e *tomlEncoder) line(s string) {
+	e.w.WriteString(s)
+	e.w.WriteByte('\n')
+	e.started = true
+}
+
+var bareKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (e *tomlEncoder) keyOf(key *yaml.Node) string {
+	if m := e.codec.layoutOf(key); len(m.raw) > 0 {
+		return m.raw
+	}
+
+	if bareKeyRe.MatchString(key.Value) {
+		return key.Value
+	}
+
+	return tomlString(key.Value)
+}
+
+// value returns the value as it is written in the input,
+// indent is the indentation of the line the value starts at.
+func (e *tomlEncoder) value(value *yaml.Node, indent string) string {
+	m := e.codec.layoutOf(value)
+
+	switch value.Kind {
+	case yaml.SequenceNode:
+		if len(value.Content) == 0 {
+			return "[]"
+		}
+
+		elems := make([]string, len(value.Content))
+
+		for i, elem := range value.Content {
+			elems[i] = e.value(elem, m.elemIndent)
+		}
+
+		if m.multiline {
+			var b strings.Builder
+
+			b.WriteString("[\n")
+
+			for _, elem := range elems {
+				b.WriteString(m.elemIndent + elem + ",\n")
+			}
+
+			b.WriteString(indent + "]")
+
+			return b.String()
+		}
+
+		return "[" + strings.Join(elems, ", ") + "]"
+
+	case yaml.MappingNode:
+		if len(value.Content) == 0 {
+			return "{}"
+		}
+
+		return "{ " + strings.Join(e.inlineKeyValues("", value), ", ") + " }"
+
+	default:
+		if len(m.raw) > 0 {
+			return m.raw
+		}
+
+		if value.ShortTag() == "!!str" {
+			return tomlString(value.Value)
+		}
+
+		return value.Value
+	}
+}
+
+func (e *tomlEncoder) inlineKeyValues(prefix string, table *yaml.Node) []string {
+	var kvs []string
+
+	for i := 0; i < len(table.Content); i += 2 {
+		key, value := table.Content[i], table.Content[i+1]
+
+		if e.codec.layoutOf(value).dotted {
+			kvs = append(kvs, e.inlineKeyValues(prefix+e.keyOf(key)+".", value)...)
+			continue
+		}
+
+		kvs = append(kvs, prefix+e.keyOf(key)+" = "+e.value(value, ""))
+	}
+
+	return kvs
+}
+
+// tomlString returns s as a TOML basic string.
+func tomlString(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
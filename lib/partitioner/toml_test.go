@@ -0,0 +1,130 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOMLCodec_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	f := func(input, expected string) {
+		t.Helper()
+
+		c := &tomlCodec{}
+
+		docs, err := c.decode([]byte(input))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = c.encode(&buf, docs)
+		require.NoError(t, err)
+		require.Equal(t, expected, buf.String())
+	}
+
+	// The comments, the indentation and the values are kept as is.
+	input, err := os.ReadFile("../../testdata/toml/blackbox.toml")
+	require.NoError(t, err)
+
+	f(string(input), string(input))
+
+	f("a.b.c = 1\nd = { e.f = 'x', g = [] }\n", "a.b.c = 1\nd = { e.f = 'x', g = [] }\n")
+	f("[a.b]\nx = 1\n", "[a.b]\nx = 1\n")
+	f("", "")
+}
+
+func TestTOMLCodec_Invalid(t *testing.T) {
+	t.Parallel()
+
+	f := func(input, expected string) {
+		t.Helper()
+
+		_, err := (&tomlCodec{}).decode([]byte(input))
+		require.ErrorContains(t, err, expected)
+	}
+
+	f("a = 1\na = 2\n", `line 2: duplicate key "a"`)
+	f("[a]\n[a]\n", `line 2: table "a" is already defined`)
+	f("a = 1\n[a.b]\n", `line 2: key "a" is not a table`)
+	f("a = [1]\n[[a]]\n", `line 2: key "a" is not an array of tables`)
+	f("a = \n", "line 1")
+}
+
+func TestRun_TOML(t *testing.T) {
+	t.Parallel()
+
+	f := func(splitPoint string, items func(v map[string]any) int) {
+		t.Helper()
+
+		inputFile, err := filepath.Abs("../../testdata/toml/blackbox.toml")
+		require.NoError(t, err)
+
+		workDir := t.TempDir()
+
+		cfg, err := NewConfig(
+			WithConsistentHashing(getConsistentHashing()),
+			WithReplicasCount(2),
+			WithSplitPoint(splitPoint),
+			WithWorkingDirectory(workDir),
+		)
+		require.NoError(t, err)
+
+		p, err := WithConfig(cfg, inputFile, "")
+		require.NoError(t, err)
+
+		err = p.Run(context.Background())
+		require.NoError(t, err)
+
+		total := 0
+
+		for _, name := range shardNames {
+			count := p.ShardItemsCount()[name]
+			if count == 0 {
+				continue
+			}
+
+			output, err := os.ReadFile(filepath.Join(workDir, name, p.OutputFile()))
+			require.NoError(t, err)
+
+			var v map[string]any
+
+			err = toml.Unmarshal(output, &v)
+			require.NoError(t, err, string(output))
+			require.Equal(t, "blackbox", v["title"])
+			require.Equal(t, count, items(v))
+
+			total += count
+		}
+
+		require.Equal(t, 2*p.totalItemsBefore, total)
+	}
+
+	f("modules", func(v map[string]any) int {
+		return len(v["modules"].(map[string]any))
+	})
+
+	f("servers", func(v map[string]any) int {
+		return len(v["servers"].([]any))
+	})
+}
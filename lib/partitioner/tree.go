@@ -0,0 +1,698 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package partitioner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tree represents the input YAML parsed once along with
+// the owners of each item found at the split point.
+// The tree is shared by all shards, each shard filters
+// the split point nodes for itself before encoding the tree.
+// Note: tree is not safe for concurrent use by multiple shards.
+type tree struct {
+	cfg *Config
+	// format is the format of the input, see DetectFormat.
+	format string
+	codec  codec
+	// docs are the DocumentNodes of the input YAML stream,
+	// docs is empty if the input YAML is empty.
+	docs []*yaml.Node
+	// stream holds the documents to encode in its Content, this is
+	// a split point node itself for the SplitDocuments split point.
+	stream *yaml.Node
+	// anchors maps anchor names to the split point items
+	// or the split point nodes defining them.
+	// Anchors are scoped to a document, so this is reset for every document.
+	anchors    map[string]anchor
+	splitNodes []*splitNode
+	aliasNodes []*aliasNode
+	// movedAnchors are the AliasNodes replaced with their AnchorNodes
+	// for the current shard, see resolveAnchors.
+	movedAnchors []movedAnchor
+	// prunedNodes are the original contents of the nodes
+	// pruned for the current shard, see prune.
+	prunedNodes []prunedNode
+	// keptContainers and prunedContainers are the numbers of
+	// the containers kept and pruned for the current shard, see prune.
+	keptContainers   int
+	prunedContainers int
+	// broadcastCount is the number of the broadcast items
+	// the current shard got, see WithBroadcast.
+	broadcastCount int
+	// itemsCountBefore is the total number of items found at the split point.
+	itemsCountBefore int
+	// stats counts the items placed by the routes and the directives.
+	stats *placementStats
+	// docItemsCountBefore is the number of items found at the split point of each document.
+	docItemsCountBefore []int
+}
+
+// splitNode represents a SequenceNode or a MappingNode found at the split point.
+type splitNode struct {
+	node *yaml.Node
+	// doc is the index of the document containing the node.
+	doc int
+	// content is the original content of the node.
+	content []*yaml.Node
+	items   []*item
+	// step is 2 for yaml.MappingNode, because its item is a kv pair, otherwise 1.
+	step int
+	// itemsAfter is the number of items the current shard got,
+	// broadcastAfter is the number of the broadcast ones among them.
+	itemsAfter     int
+	broadcastAfter int
+	// ancestors are the nodes on the path from the document root
+	// down to the node, the last one is its container, see prune.
+	ancestors []*yaml.Node
+}
+
+// aliasNode represents an AliasNode found at the split point.
+type aliasNode struct {
+	node *yaml.Node
+	doc  int
+	step int
+	// anchor is the split point node the alias refers to, if any.
+	anchor *splitNode
+	// ancestors are the same as for splitNode.
+	ancestors []*yaml.Node
+}
+
+// item represents a single item of a split point node.
+type item struct {
+	// key is nil for items of a SequenceNode.
+	key   *yaml.Node
+	value *yaml.Node
+	// owners is the set of shards that get the item.
+	owners map[string]struct{}
+	// merge is true for a merge key kept in every shard,
+	// which is not counted as an item, see MergeKeysKeep.
+	merge bool
+	// broadcast is true for an item kept on every shard, see WithBroadcast.
+	broadcast bool
+	// dropped is true for an item removed from every shard,
+	// which is not counted as an item, see WithDrop.
+	dropped bool
+}
+
+// movedAnchor is an AliasNode replaced with its AnchorNode
+// at the index of the parent node content.
+type movedAnchor struct {
+	parent *yaml.Node
+	index  int
+	alias  *yaml.Node
+}
+
+// prunedNode is a node along with its content before pruning.
+type prunedNode struct {
+	node    *yaml.Node
+	content []*yaml.Node
+}
+
+// anchor refers to either an item or a split point node defining the anchor.
+type anchor struct {
+	item      *item
+	splitNode *splitNode
+}
+
+// newTree parses all documents of the input YAML stream and computes
+// the owners of each item found at the split point of every document.
+// The documents without the split point are kept as is, but the split point
+// must be found in at least one of them. The format of the input is detected
+// by name unless it is set in the config, name is used in error messages as well.
+func newTree(ctx context.Context, cfg *Config, name string, input []byte) (*tree, error) {
+	format := cfg.formatOf(name)
+	c := newCodec(format, cfg)
+
+	docs, err := c.decode(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s for %s: %w", format, name, err)
+	}
+
+	t := &tree{
+		cfg:                 cfg,
+		format:              format,
+		codec:               c,
+		docs:                docs,
+		stream:              &yaml.Node{Kind: yaml.SequenceNode, Content: docs},
+		docItemsCountBefore: make([]int, len(docs)),
+		stats:               newPlacementStats(cfg),
+	}
+
+	// Nothing to partition in the empty input.
+	if len(docs) == 0 {
+		return t, nil
+	}
+
+	if cfg.splitPoint.documents {
+		if err := t.addDocuments(); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s for %s: %w", format, name, err)
+		}
+
+		return t, nil
+	}
+
+	found := false
+
+	for i, doc := range docs {
+		t.anchors = make(map[string]anchor, 100)
+		itemsCountBefore := t.itemsCountBefore
+
+		err := cfg.splitPoint.walk(ctx, doc, func(node *yaml.Node, path []string) error {
+			found = true
+			return t.addSplitNode(i, node, ancestorsOf(doc, node, path))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s for %s: %w", format, name, err)
+		}
+
+		t.docItemsCountBefore[i] = t.itemsCountBefore - itemsCountBefore
+	}
+
+	if !found {
+		return nil, fmt.Errorf("failed to unmarshal %s for %s: split point path %q not found", format, name, cfg.splitPoint)
+	}
+
+	return t, nil
+}
+
+// addDocuments makes each document an item of the stream.
+// The stream is counted as a single document in the report.
+func (t *tree) addDocuments() error {
+	sn := &splitNode{
+		node:    t.stream,
+		content: t.stream.Content,
+		items:   make([]*item, 0, len(t.docs)),
+		step:    1,
+	}
+
+	for _, doc := range t.docs {
+		it := &item{value: doc}
+
+		// The empty document has nothing to hash, and nothing to deliver.
+		if len(doc.Content) == 0 {
+			it.owners = map[string]struct{}{}
+		} else {
+			key, err := ItemKey(doc.Content[0])
+			if err != nil {
+				return err
+			}
+
+			pl, err := t.placementOf(nil, doc.Content[0], key)
+			if err != nil {
+				return err
+			}
+
+			it.owners = ownerSet(pl.owners)
+			it.broadcast = pl.broadcast
+			it.dropped = pl.dropped != nil
+		}
+
+		sn.items = append(sn.items, it)
+	}
+
+	t.splitNodes = append(t.splitNodes, sn)
+	t.itemsCountBefore = sn.itemsCount()
+	t.docItemsCountBefore = []int{t.itemsCountBefore}
+
+	return nil
+}
+
+func (t *tree) addSplitNode(doc int, node *yaml.Node, ancestors []*yaml.Node) error {
+	switch node.Kind { //nolint
+	case yaml.SequenceNode, yaml.MappingNode:
+		sn := &splitNode{
+			node:      node,
+			doc:       doc,
+			content:   node.Content,
+			step:      1,
+			ancestors: ancestors,
+		}
+
+		if node.Kind == yaml.MappingNode {
+			// step is 2 because yaml.MappingNode item is a kv pair
+			sn.step = 2
+		}
+
+		if len(node.Anchor) > 0 { // SplitPoint is AnchorNode
+			t.anchors[node.Anchor] = anchor{splitNode: sn}
+		}
+
+		if node.Kind == yaml.MappingNode && t.cfg.mergeKeys == MergeKeysExpand && hasMergeKeys(node) {
+			content, err := expandMergeKeys(node)
+			if err != nil {
+				return err
+			}
+
+			node.Content = content
+			sn.content = content
+		}
+
+		sn.items = make([]*item, 0, len(node.Content)/sn.step)
+
+		for i := 0; i < len(node.Content); i += sn.step {
+			it := &item{}
+
+			if node.Kind == yaml.MappingNode {
+				it.key = node.Content[i]
+				it.value = node.Content[i+1]
+			} else {
+				it.value = node.Content[i]
+			}
+
+			if it.key != nil && isMergeKey(it.key) {
+				it.owners = t.allShards()
+				it.merge = true
+			} else {
+				if err := t.place(it); err != nil {
+					return err
+				}
+
+				if !it.dropped {
+					t.itemsCountBefore++
+				}
+			}
+
+			sn.items = append(sn.items, it)
+		}
+
+		t.splitNodes = append(t.splitNodes, sn)
+
+	case yaml.AliasNode:
+		an := &aliasNode{node: node, doc: doc, ancestors: ancestors}
+
+		switch node.Alias.Kind { //nolint
+		case yaml.SequenceNode:
+			an.step = 1
+		case yaml.MappingNode:
+			// step is 2 because yaml.MappingNode item is a kv pair
+			an.step = 2
+		default:
+			return fmt.Errorf("invalid split point path: node at %q is not shardable", t.cfg.splitPoint)
+		}
+
+		// The items of the AnchorNode are counted once again.
+		if a, ok := t.anchors[node.Value]; ok && a.splitNode != nil {
+			an.anchor = a.splitNode
+			t.itemsCountBefore += a.splitNode.itemsCount()
+		}
+
+		t.aliasNodes = append(t.aliasNodes, an)
+
+	default:
+		return fmt.Errorf("invalid split point path: node at %q is not shardable", t.cfg.splitPoint)
+	}
+
+	return nil
+}
+
+// place computes the owners of the item.
+func (t *tree) place(it *item) error {
+	if it.value.Kind == yaml.AliasNode {
+		// AliasNode follows its AnchorNode, which has already been processed.
+		a, ok := t.anchors[it.value.Value]
+
+		switch {
+		case !ok:
+			it.owners = map[string]struct{}{}
+		case a.item != nil:
+			it.owners = a.item.owners
+			it.broadcast = a.item.broadcast
+			it.dropped = a.item.dropped
+		case len(a.splitNode.items) > 0:
+			it.owners = t.allShards()
+		default:
+			it.owners = map[string]struct{}{}
+		}
+
+		if t.cfg.stripDirectives {
+			stripDirectives(it.key, it.value)
+		}
+
+		return nil
+	}
+
+	key, err := ItemKey(it.value)
+	if err != nil {
+		return err
+	}
+
+	pl, err := t.placementOf(it.key, it.value, key)
+	if err != nil {
+		return err
+	}
+
+	it.owners = ownerSet(pl.owners)
+	it.broadcast = pl.broadcast
+	it.dropped = pl.dropped != nil
+
+	if len(it.value.Anchor) > 0 { // AnchorNode
+		t.anchors[it.value.Anchor] = anchor{item: it}
+	}
+
+	return nil
+}
+
+// itemsCount returns the number of items of the split point node
+// not counting the merge keys and the dropped items.
+func (sn *splitNode) itemsCount() int {
+	count := 0
+
+	for _, it := range sn.items {
+		if !it.merge && !it.dropped {
+			count++
+		}
+	}
+
+	return count
+}
+
+// emptyInInput reports whether the split point node had no items
+// in the input, not counting the merge keys. The dropped items are
+// counted, so a node left without items by dropping is not kept as is.
+func (sn *splitNode) emptyInInput() bool {
+	for _, it := range sn.items {
+		if !it.merge {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ancestorsOf returns the nodes on the path from the document root
+// down to the node found at the path, not including the node itself.
+// It returns nil if the path leads to another node, e.g. due to duplicate keys.
+func ancestorsOf(doc, node *yaml.Node, path []string) []*yaml.Node {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+
+	ancestors := make([]*yaml.Node, 0, len(path))
+	current := doc.Content[0]
+
+	for _, elem := range path {
+		ancestors = append(ancestors, current)
+
+		var next *yaml.Node
+
+		switch current.Kind { //nolint
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(elem); err == nil && i < len(current.Content) {
+				next = current.Content[i]
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(current.Content); i += 2 {
+				if current.Content[i].Value == elem {
+					next = current.Content[i+1]
+					break
+				}
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		current = next
+	}
+
+	if current != node {
+		return nil
+	}
+
+	return ancestors
+}
+
+// stepOf returns 2 for yaml.MappingNode, because its item is a kv pair, otherwise 1.
+func stepOf(node *yaml.Node) int {
+	if node.Kind == yaml.MappingNode {
+		return 2
+	}
+
+	return 1
+}
+
+// placementOf tells which shards get the item, see Config.place.
+// The directives are removed from the item if WithStripDirectives is set.
+func (t *tree) placementOf(key, item *yaml.Node, hashKey []byte) (*placement, error) {
+	pl, err := t.cfg.place(key, item, hashKey)
+	if err != nil {
+		return nil, err
+	}
+
+	t.stats.add(pl, item.Line)
+
+	if t.cfg.stripDirectives {
+		stripDirectives(key, item)
+	}
+
+	return pl, nil
+}
+
+func (t *tree) allShards() map[string]struct{} {
+	res := make(map[string]struct{}, t.cfg.NodesCount())
+	for _, name := range t.cfg.NodeNames() {
+		res[name] = struct{}{}
+	}
+
+	return res
+}
+
+// filter sets the content of the split point nodes to
+// the items that belong to the shard and returns
+// the number of items the shard got in each document.
+// The number of the broadcast items among them is set to broadcastCount.
+func (t *tree) filter(shardName string) []int {
+	// The anchors are moved and the nodes are pruned
+	// in the content filtered for the previous shard.
+	t.restoreAnchors()
+	t.restorePruned()
+
+	counts := make([]int, len(t.docItemsCountBefore))
+	t.broadcastCount = 0
+
+	for _, sn := range t.splitNodes {
+		newContent := make([]*yaml.Node, 0, len(sn.content))
+		sn.itemsAfter = 0
+		sn.broadcastAfter = 0
+
+		for _, it := range sn.items {
+			if _, ok := it.owners[shardName]; !ok {
+				continue
+			}
+
+			if !it.merge {
+				sn.itemsAfter++
+
+				if it.broadcast {
+					sn.broadcastAfter++
+				}
+			}
+
+			if it.key != nil {
+				newContent = append(newContent, it.key, it.value)
+			} else {
+				newContent = append(newContent, it.value)
+			}
+		}
+
+		sn.node.Content = newContent
+		counts[sn.doc] += sn.itemsAfter
+		t.broadcastCount += sn.broadcastAfter
+	}
+
+	for _, an := range t.aliasNodes {
+		// AnchorNode has already been filtered.
+		if an.anchor != nil {
+			counts[an.doc] += an.anchor.itemsAfter
+			t.broadcastCount += an.anchor.broadcastAfter
+		} else {
+			counts[an.doc] += len(an.node.Alias.Content) / an.step
+		}
+	}
+
+	// The pruned nodes may define anchors, so they are resolved afterwards.
+	t.prune()
+	t.resolveAnchors()
+
+	return counts
+}
+
+// resolveAnchors makes every AliasNode of the filtered documents refer
+// to an AnchorNode defined before it. The AnchorNode may be in an item
+// that went to another shard, e.g. an alias in a kept item may refer
+// to an anchor defined deep inside a dropped item. In this case
+// the first remaining AliasNode is replaced with the AnchorNode,
+// so the anchor is defined at its first remaining use,
+// and the following AliasNodes refer to it as usual.
+func (t *tree) resolveAnchors() {
+	for _, doc := range t.stream.Content {
+		// Anchors are scoped to a document.
+		defined := make(map[*yaml.Node]struct{})
+		t.resolveNodeAnchors(doc, defined)
+	}
+}
+
+// resolveNodeAnchors walks the content of node in the order it is encoded.
+func (t *tree) resolveNodeAnchors(node *yaml.Node, defined map[*yaml.Node]struct{}) {
+	for i, n := range node.Content {
+		if n.Kind == yaml.AliasNode && n.Alias != nil {
+			if _, ok := defined[n.Alias]; ok {
+				continue
+			}
+
+			t.movedAnchors = append(t.movedAnchors, movedAnchor{parent: node, index: i, alias: n})
+			node.Content[i] = n.Alias
+			n = n.Alias
+		}
+
+		if len(n.Anchor) > 0 {
+			defined[n] = struct{}{}
+		}
+
+		t.resolveNodeAnchors(n, defined)
+	}
+}
+
+// restoreAnchors puts the AliasNodes replaced by resolveAnchors back.
+func (t *tree) restoreAnchors() {
+	for i := len(t.movedAnchors) - 1; i >= 0; i-- {
+		m := t.movedAnchors[i]
+		m.parent.Content[m.index] = m.alias
+	}
+
+	t.movedAnchors = t.movedAnchors[:0]
+}
+
+// prune removes the containers of the split point nodes, which got no items
+// in the current shard, from their parent nodes. With PruneEmptyAncestors
+// the parent nodes left empty are removed as well, and so on up to
+// the document root, which is never removed. The split point nodes,
+// which were empty in the input, are kept as is.
+func (t *tree) prune() {
+	t.keptContainers = 0
+	t.prunedContainers = 0
+
+	if t.cfg.pruneEmpty == PruneEmptyNone {
+		return
+	}
+
+	// parents maps the ancestors of the empty containers to their parents.
+	parents := make(map[*yaml.Node]*yaml.Node)
+
+	var level []*yaml.Node
+
+	addContainer := func(ancestors []*yaml.Node, empty bool) {
+		if len(ancestors) < 2 {
+			// The container is the document root.
+			return
+		}
+
+		if !empty {
+			t.keptContainers++
+			return
+		}
+
+		for i := 1; i < len(ancestors); i++ {
+			parents[ancestors[i]] = ancestors[i-1]
+		}
+
+		level = append(level, ancestors[len(ancestors)-1])
+	}
+
+	for _, sn := range t.splitNodes {
+		addContainer(sn.ancestors, sn.itemsAfter == 0 && !sn.emptyInInput())
+	}
+
+	for _, an := range t.aliasNodes {
+		addContainer(an.ancestors, an.anchor != nil && an.anchor.itemsAfter == 0 && !an.anchor.emptyInInput())
+	}
+
+	for len(level) > 0 {
+		removed := make(map[*yaml.Node]struct{}, len(level))
+		order := make([]*yaml.Node, 0, len(level))
+
+		seen := make(map[*yaml.Node]struct{}, len(level))
+
+		for _, node := range level {
+			if _, ok := removed[node]; ok {
+				continue
+			}
+
+			removed[node] = struct{}{}
+
+			// The parents are pruned in the order they are found.
+			parent := parents[node]
+			if _, ok := seen[parent]; !ok {
+				seen[parent] = struct{}{}
+				order = append(order, parent)
+			}
+		}
+
+		level = nil
+
+		for _, parent := range order {
+			content := make([]*yaml.Node, 0, len(parent.Content))
+
+			if parent.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(parent.Content); i += 2 {
+					if _, ok := removed[parent.Content[i+1]]; !ok {
+						content = append(content, parent.Content[i], parent.Content[i+1])
+					}
+				}
+			} else {
+				for _, child := range parent.Content {
+					if _, ok := removed[child]; !ok {
+						content = append(content, child)
+					}
+				}
+			}
+
+			t.prunedNodes = append(t.prunedNodes, prunedNode{node: parent, content: parent.Content})
+			t.prunedContainers += (len(parent.Content) - len(content)) / stepOf(parent)
+			parent.Content = content
+
+			if _, ok := parents[parent]; ok && len(content) == 0 && t.cfg.pruneEmpty == PruneEmptyAncestors {
+				level = append(level, parent)
+			}
+		}
+	}
+}
+
+// restorePruned puts the nodes removed by prune back.
+func (t *tree) restorePruned() {
+	for i := len(t.prunedNodes) - 1; i >= 0; i-- {
+		pn := t.prunedNodes[i]
+		pn.node.Content = pn.content
+	}
+
+	t.prunedNodes = t.prunedNodes[:0]
+}
+
+// restore sets the content of the split point nodes to the original one.
+func (t *tree) restore() {
+	t.restoreAnchors()
+	t.restorePruned()
+
+	for _, sn := range t.splitNodes {
+		sn.node.Content = sn.content
+	}
+}
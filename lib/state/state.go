@@ -0,0 +1,219 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package state implements tracking of the files
+// *yp* manages in the output directory.
+//
+// The state file lists the files *yp* has ever produced in the output
+// directory and has not removed yet, so the files that are not produced
+// anymore can be removed without touching unrelated files.
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileName is the name of the state file in the output directory.
+const FileName = ".yp-state.json"
+
+// State represents the files owned by *yp* in the output directory.
+type State struct {
+	// Files is the sorted list of the owned files relative
+	// to the output directory, e.g. "alpha/rules/kube.yml".
+	Files []string `json:"files"`
+}
+
+// Load reads the state file from dir.
+// The empty State is returned if there is no state file.
+func Load(dir string) (*State, error) {
+	stateFile := filepath.Join(dir, FileName)
+
+	b, err := os.ReadFile(stateFile)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return &State{}, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	s := &State{}
+
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state file %q: %w", stateFile, err)
+	}
+
+	sort.Strings(s.Files)
+
+	return s, nil
+}
+
+// Save writes the state file to dir.
+// The state file is replaced atomically, and it is not
+// rewritten if its contents are the same.
+func (s *State) Save(dir string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	b = append(b, '\n')
+
+	stateFile := filepath.Join(dir, FileName)
+
+	if current, err := os.ReadFile(stateFile); err == nil && bytes.Equal(current, b) {
+		return nil
+	}
+
+	tmpFile := stateFile + ".tmp"
+
+	if err := os.WriteFile(tmpFile, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmpFile, stateFile); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+// Add adds the files to the owned ones.
+func (s *State) Add(files ...string) {
+	owned := s.set()
+	for _, file := range files {
+		owned[file] = struct{}{}
+	}
+
+	s.setFiles(owned)
+}
+
+// Remove removes the files from the owned ones.
+func (s *State) Remove(files ...string) {
+	owned := s.set()
+	for _, file := range files {
+		delete(owned, file)
+	}
+
+	s.setFiles(owned)
+}
+
+// Stale returns the owned files that are not in produced.
+// If scope is not empty, only the owned files under
+// one of the scope directories are returned.
+func (s *State) Stale(produced []string, scope ...string) []string {
+	producedSet := make(map[string]struct{}, len(produced))
+	for _, file := range produced {
+		producedSet[file] = struct{}{}
+	}
+
+	var stale []string
+
+	for _, file := range s.Files {
+		if _, ok := producedSet[file]; ok {
+			continue
+		}
+
+		if !inScope(file, scope) {
+			continue
+		}
+
+		stale = append(stale, file)
+	}
+
+	return stale
+}
+
+// Prune removes the given files from dir along with the state entries.
+// The files that do not exist anymore are considered removed.
+// The directories left empty are removed as well, but dir itself is kept.
+func (s *State) Prune(dir string, files []string) error {
+	removed := make([]string, 0, len(files))
+
+	for _, file := range files {
+		// The state file may be edited by hand, so the files
+		// outside of dir are never removed.
+		if !filepath.IsLocal(filepath.FromSlash(file)) {
+			return fmt.Errorf("refusing to remove %q outside of %q", file, dir)
+		}
+
+		err := os.Remove(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.Remove(removed...)
+			return fmt.Errorf("failed to remove stale file: %w", err)
+		}
+
+		removed = append(removed, file)
+
+		removeEmptyParents(dir, file)
+	}
+
+	s.Remove(removed...)
+
+	return nil
+}
+
+// removeEmptyParents removes the parent directories of the file
+// relative to dir, which are empty, from the deepest one up to dir.
+// The directories with other files are kept, so is dir itself.
+func removeEmptyParents(dir, file string) {
+	for parent := path.Dir(file); parent != "." && parent != "/"; parent = path.Dir(parent) {
+		err := os.Remove(filepath.Join(dir, filepath.FromSlash(parent)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			// The directory is not empty.
+			return
+		}
+	}
+}
+
+func (s *State) set() map[string]struct{} {
+	owned := make(map[string]struct{}, len(s.Files))
+	for _, file := range s.Files {
+		owned[file] = struct{}{}
+	}
+
+	return owned
+}
+
+func (s *State) setFiles(owned map[string]struct{}) {
+	s.Files = make([]string, 0, len(owned))
+	for file := range owned {
+		s.Files = append(s.Files, file)
+	}
+
+	sort.Strings(s.Files)
+}
+
+func inScope(file string, scope []string) bool {
+	if len(scope) == 0 {
+		return true
+	}
+
+	for _, dir := range scope {
+		if strings.HasPrefix(file, path.Clean(dir)+"/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,116 @@
+// Copyright 2023-2024 Andrew Sokolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSave(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// No state file yet
+	s, err := Load(dir)
+	require.NoError(t, err)
+	require.Empty(t, s.Files)
+
+	s.Add("beta/b.yml", "alpha/a.yml", "beta/b.yml")
+	require.NoError(t, s.Save(dir))
+
+	s, err = Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alpha/a.yml", "beta/b.yml"}, s.Files)
+
+	// The state file is not rewritten if nothing changed.
+	stateFile := filepath.Join(dir, FileName)
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(stateFile, past, past))
+	require.NoError(t, s.Save(dir))
+
+	info, err := os.Stat(stateFile)
+	require.NoError(t, err)
+	require.Equal(t, past, info.ModTime())
+
+	s.Add("gamma/c.yml")
+	require.NoError(t, s.Save(dir))
+
+	info, err = os.Stat(stateFile)
+	require.NoError(t, err)
+	require.NotEqual(t, past, info.ModTime())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, FileName), []byte("{"), 0o644))
+
+	_, err = Load(dir)
+	require.ErrorContains(t, err, "failed to unmarshal state file")
+}
+
+func TestStale(t *testing.T) {
+	t.Parallel()
+
+	f := func(owned, produced, scope, expected []string) {
+		t.Helper()
+
+		s := &State{}
+		s.Add(owned...)
+		require.Equal(t, expected, s.Stale(produced, scope...))
+	}
+
+	f(nil, []string{"alpha/a.yml"}, nil, nil)
+	f([]string{"alpha/a.yml", "alpha/b.yml"}, []string{"alpha/a.yml"}, nil,
+		[]string{"alpha/b.yml"})
+	// The files of the other shards are out of scope.
+	f([]string{"alpha/a.yml", "alpha/b.yml", "beta/b.yml"}, []string{"alpha/a.yml"}, []string{"alpha"},
+		[]string{"alpha/b.yml"})
+	f([]string{"alpha/a.yml", "alphabet/b.yml"}, nil, []string{"alpha/"},
+		[]string{"alpha/a.yml"})
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	for _, file := range []string{"alpha/a.yml", "alpha/b.yml", "alpha/unrelated.yml", "beta/rules/team/c.yml"} {
+		path := filepath.Join(dir, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("a: 1\n"), 0o644))
+	}
+
+	s := &State{}
+	s.Add("alpha/a.yml", "alpha/b.yml", "alpha/gone.yml")
+
+	require.NoError(t, s.Prune(dir, []string{"alpha/b.yml", "alpha/gone.yml"}))
+	require.Equal(t, []string{"alpha/a.yml"}, s.Files)
+	require.FileExists(t, filepath.Join(dir, "alpha/a.yml"))
+	require.NoFileExists(t, filepath.Join(dir, "alpha/b.yml"))
+	require.FileExists(t, filepath.Join(dir, "alpha/unrelated.yml"))
+
+	// The directories left empty are removed up to dir.
+	s.Add("beta/rules/team/c.yml")
+	require.NoError(t, s.Prune(dir, []string{"beta/rules/team/c.yml", "alpha/a.yml"}))
+	require.NoDirExists(t, filepath.Join(dir, "beta"))
+	require.DirExists(t, filepath.Join(dir, "alpha"))
+	require.DirExists(t, dir)
+
+	err := s.Prune(dir, []string{"../outside.yml"})
+	require.ErrorContains(t, err, "refusing to remove")
+}